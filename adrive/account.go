@@ -0,0 +1,92 @@
+package adrive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+)
+
+// AccountInfo 当前账号和网盘信息
+func (fs *FileSystem) AccountInfo(ctx context.Context) (*alipanopen.GetCurrentUserResp, *alipanopen.GetDriveInfoResp, error) {
+	var user *alipanopen.GetCurrentUserResp
+	err := fs.call(ctx, func(client *alipanopen.Client) (err error) {
+		user, err = client.GetCurrentUser(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var driveInfo *alipanopen.GetDriveInfoResp
+	err = fs.call(ctx, func(client *alipanopen.Client) (err error) {
+		driveInfo, err = client.GetDriveInfo(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, driveInfo, nil
+}
+
+// RevokeToken 作废并清除已保存的 token, 无需登录即可调用.
+// 开放平台没有吊销接口, refreshToken 使用一次后即失效, 因此刷新一次并丢弃新 token.
+func RevokeToken(ctx context.Context, config AlipanConfig, tokenStore TokenStore) error {
+	tm := newTokenManager(config.ClientId, config.ClientSecret, tokenStore, config.AlertWebhook)
+	return tm.revoke(ctx)
+}
+
+func (tm *tokenManager) revoke(ctx context.Context) error {
+	tm.refreshMu.Lock()
+	defer tm.refreshMu.Unlock()
+
+	token, err := tm.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return nil
+	}
+
+	if token.RefreshToken != "" {
+		reqBody := &alipanopen.RefreshTokenReq{
+			ClientId:     tm.clientId,
+			ClientSecret: tm.clientSecret,
+			RefreshToken: token.RefreshToken,
+			GrantType:    alipanopen.GRANT_TYPE_REFRESH_TOKEN,
+		}
+		_, err := tm.authClient.RefreshToken(ctx, reqBody)
+		if err != nil {
+			logger.Warnf("作废 refreshToken 失败, 可能已失效: %v", err)
+		}
+	}
+
+	err = tm.store.CompareAndSwap(ctx, token.Version, &Token{})
+	if err != nil {
+		return errors.Wrap(err, "清除 token 失败")
+	}
+
+	tm.mu.Lock()
+	tm.token = nil
+	tm.client = nil
+	tm.mu.Unlock()
+
+	return nil
+}
+
+// RemoveDB 删除数据目录下的数据库文件, 需先关闭数据库
+func RemoveDB(dataDir string) error {
+	if dataDir == "" {
+		dataDir = "."
+	}
+
+	err := os.Remove(filepath.Join(dataDir, dbFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
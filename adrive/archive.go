@@ -0,0 +1,258 @@
+package adrive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+)
+
+const (
+	ARCHIVE_FORMAT_ZIP = "zip"
+	ARCHIVE_FORMAT_TAR = "tar"
+)
+
+// 提前获取下载链接的文件数
+const archivePrefetchFiles = 3
+
+// 已压缩的媒体文件分类, zip 中不再压缩
+var archiveStoreCategories = []string{"image", "video", "audio", "zip"}
+
+// archiveEntry 压缩包中的文件或文件夹, 文件在写入前提前获取下载链接.
+// 只获取链接不打开连接, 避免连接在等待写入期间空闲超时.
+type archiveEntry struct {
+	name string // 压缩包中的路径
+	fi   *FileInfo
+
+	err  error
+	done chan struct{}
+}
+
+func newArchiveEntry(name string, fi *FileInfo) *archiveEntry {
+	e := &archiveEntry{name: name, fi: fi, done: make(chan struct{})}
+	if !e.hasContent() {
+		close(e.done)
+	}
+	return e
+}
+
+// hasContent 文件夹和空文件不需要下载
+func (e *archiveEntry) hasContent() bool {
+	return !e.fi.IsDir() && e.fi.Size() > 0
+}
+
+// prefetch 获取下载链接并缓存, 完成后关闭 done
+func (e *archiveEntry) prefetch(fs *FileSystem) {
+	defer close(e.done)
+
+	_, e.err = fs.getDownloadUrl(e.fi.DriveId, e.fi.FileId, e.fi.ContentHash)
+}
+
+// archiveWriter 按顺序写入文件和文件夹, 文件夹和空文件的 r 为 nil
+type archiveWriter interface {
+	add(e *archiveEntry, r io.Reader) error
+	Close() error
+}
+
+type zipArchiveWriter struct {
+	w *zip.Writer
+}
+
+func (aw *zipArchiveWriter) add(e *archiveEntry, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     e.name,
+		Modified: e.fi.ModTime(),
+		Method:   zip.Deflate,
+	}
+	if e.fi.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+	} else if containsString(archiveStoreCategories, e.fi.Category) {
+		header.Method = zip.Store
+	}
+
+	w, err := aw.w.CreateHeader(header)
+	if err != nil || r == nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (aw *zipArchiveWriter) Close() error {
+	return aw.w.Close()
+}
+
+type tarArchiveWriter struct {
+	w *tar.Writer
+}
+
+func (aw *tarArchiveWriter) add(e *archiveEntry, r io.Reader) error {
+	header := &tar.Header{
+		Name:     e.name,
+		Mode:     0644,
+		Size:     e.fi.Size(),
+		ModTime:  e.fi.ModTime(),
+		Typeflag: tar.TypeReg,
+	}
+	if e.fi.IsDir() {
+		header.Name += "/"
+		header.Mode = 0755
+		header.Size = 0
+		header.Typeflag = tar.TypeDir
+	}
+
+	err := aw.w.WriteHeader(header)
+	if err != nil || r == nil {
+		return err
+	}
+
+	_, err = io.Copy(aw.w, r)
+	return err
+}
+
+func (aw *tarArchiveWriter) Close() error {
+	return aw.w.Close()
+}
+
+// writeArchiveEntry 下载文件并写入压缩包
+func (fs *FileSystem) writeArchiveEntry(aw archiveWriter, e *archiveEntry) error {
+	if !e.hasContent() {
+		return aw.add(e, nil)
+	}
+
+	f := NewReadableFile(e.fi, fs)
+	defer f.Close()
+	return aw.add(e, f)
+}
+
+// walkArchive 递归列举文件夹, 按文件名顺序发送文件和文件夹, 忽略虚拟文件, 同一文件夹中重名的文件名后加上文件 ID.
+// 发送文件前在后台获取其下载链接, 已发送未写入的文件数受 entries 的容量限制.
+func (fs *FileSystem) walkArchive(ctx context.Context, dir *FileInfo, prefix string, entries chan<- *archiveEntry) error {
+	files, err := fs.listDir(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	var items []*alipanopen.File
+	for _, file := range files {
+		if !file.IsVirtual() {
+			items = append(items, file.File)
+		}
+	}
+	named := uniqueFileNames(items)
+	names := make([]string, 0, len(named))
+	for fileName := range named {
+		names = append(names, fileName)
+	}
+	sort.Strings(names)
+
+	for _, fileName := range names {
+		file := NewFileInfo(named[fileName], dir.fileMode)
+		e := newArchiveEntry(path.Join(prefix, fileName), file)
+		if e.hasContent() {
+			go e.prefetch(fs)
+		}
+
+		select {
+		case entries <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if file.IsDir() {
+			if err := fs.walkArchive(ctx, file, e.name, entries); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// serveArchive 处理带 archive 参数的 GET 请求, 将文件夹打包为 zip 或 tar 边下载边返回
+func (fs *FileSystem) serveArchive(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("archive")
+	if format != ARCHIVE_FORMAT_ZIP && format != ARCHIVE_FORMAT_TAR {
+		http.Error(w, "不支持的打包格式, 可选: zip, tar", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	name := fs.resolve(strings.TrimPrefix(r.URL.Path, fs.urlPrefix))
+	dir, err := fs.getFile(ctx, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !dir.IsDir() || dir.IsVirtual() {
+		http.Error(w, "只能打包网盘中的文件夹", http.StatusBadRequest)
+		return
+	}
+
+	dirName := path.Base(name)
+	if name == "/" {
+		dirName = util.Name
+	}
+
+	fileName := dirName + "." + format
+	w.Header().Set("Content-Type", "application/"+map[string]string{ARCHIVE_FORMAT_ZIP: "zip", ARCHIVE_FORMAT_TAR: "x-tar"}[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(fileName)))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	var aw archiveWriter
+	if format == ARCHIVE_FORMAT_ZIP {
+		aw = &zipArchiveWriter{w: zip.NewWriter(w)}
+	} else {
+		aw = &tarArchiveWriter{w: tar.NewWriter(w)}
+	}
+
+	// 边列举边打包, 出错或客户端断开时 cancel 使列举停止
+	entries := make(chan *archiveEntry, archivePrefetchFiles)
+	var walkErr error
+	go func() {
+		defer close(entries)
+		walkErr = fs.walkArchive(ctx, dir, dirName, entries)
+	}()
+
+	logger.Infof("开始打包下载 '%s'", name)
+	count := 0
+	for e := range entries {
+		<-e.done
+		err = e.err
+		if err == nil {
+			err = fs.writeArchiveEntry(aw, e)
+		}
+		if err != nil {
+			// 已开始返回内容, 中断连接使客户端知道下载失败
+			logger.Errorf("打包下载 '%s' 失败, 文件 '%s': %v", name, e.name, err)
+			panic(http.ErrAbortHandler)
+		}
+		count++
+	}
+	if walkErr != nil {
+		logger.Errorf("打包下载 '%s' 失败, 列举文件夹失败: %v", name, walkErr)
+		panic(http.ErrAbortHandler)
+	}
+
+	err = aw.Close()
+	if err != nil {
+		logger.Errorf("打包下载 '%s' 失败: %v", name, err)
+		panic(http.ErrAbortHandler)
+	}
+	logger.Infof("打包下载 '%s' 成功, 共 %d 个文件和文件夹", name, count)
+}
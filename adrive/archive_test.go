@@ -0,0 +1,129 @@
+package adrive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+)
+
+type testArchiveFile struct {
+	name     string
+	category string
+	content  string
+	dir      bool
+}
+
+var testArchiveFiles = []testArchiveFile{
+	{name: "相册", dir: true},
+	{name: "相册/a.jpg", category: "image", content: "jpeg"},
+	{name: "相册/b.mp4", category: "video", content: "mp4"},
+	{name: "相册/说明.txt", category: "doc", content: strings.Repeat("text ", 100)},
+	{name: "相册/empty.txt", category: "doc"},
+}
+
+func writeTestArchive(t *testing.T, aw archiveWriter) {
+	modTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, f := range testArchiveFiles {
+		file := &alipanopen.File{
+			FileName:  f.name,
+			FileSize:  int64(len(f.content)),
+			Category:  f.category,
+			Type:      alipanopen.FILE_TYPE_FILE,
+			UpdatedAt: modTime,
+		}
+		var r io.Reader
+		if f.dir {
+			file.Type = alipanopen.FILE_TYPE_FOLDER
+		} else if f.content != "" {
+			r = strings.NewReader(f.content)
+		}
+
+		if err := aw.add(newArchiveEntry(f.name, NewFileInfo(file, 0)), r); err != nil {
+			t.Fatalf("add(%s) error: %v", f.name, err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+}
+
+func TestZipArchiveWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writeTestArchive(t, &zipArchiveWriter{w: zip.NewWriter(&buf)})
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader() error: %v", err)
+	}
+	if len(zr.File) != len(testArchiveFiles) {
+		t.Fatalf("zip has %d entries, want %d", len(zr.File), len(testArchiveFiles))
+	}
+
+	for i, f := range testArchiveFiles {
+		zf := zr.File[i]
+		wantName := f.name
+		if f.dir {
+			wantName += "/"
+		}
+		if zf.Name != wantName {
+			t.Errorf("entry %d name = %q, want %q", i, zf.Name, wantName)
+		}
+
+		// 图片、视频等已压缩的文件和文件夹不再压缩
+		wantMethod := zip.Deflate
+		if f.dir || containsString(archiveStoreCategories, f.category) {
+			wantMethod = zip.Store
+		}
+		if zf.Method != wantMethod {
+			t.Errorf("entry %s method = %d, want %d", f.name, zf.Method, wantMethod)
+		}
+
+		if f.dir {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatalf("Open(%s) error: %v", f.name, err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || string(data) != f.content {
+			t.Errorf("entry %s content = %q, %v, want %q", f.name, data, err, f.content)
+		}
+	}
+}
+
+func TestTarArchiveWriter(t *testing.T) {
+	var buf bytes.Buffer
+	writeTestArchive(t, &tarArchiveWriter{w: tar.NewWriter(&buf)})
+
+	tr := tar.NewReader(&buf)
+	for _, f := range testArchiveFiles {
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Next() error: %v, want entry %s", err, f.name)
+		}
+
+		wantName, wantType := f.name, byte(tar.TypeReg)
+		if f.dir {
+			wantName, wantType = f.name+"/", tar.TypeDir
+		}
+		if header.Name != wantName || header.Typeflag != wantType || header.Size != int64(len(f.content)) {
+			t.Errorf("header = %q, %c, %d, want %q, %c, %d", header.Name, header.Typeflag, header.Size, wantName, wantType, len(f.content))
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil || string(data) != f.content {
+			t.Errorf("entry %s content = %q, %v, want %q", f.name, data, err, f.content)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("Next() after last entry error = %v, want EOF", err)
+	}
+}
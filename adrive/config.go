@@ -1,6 +1,9 @@
 package adrive
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 
 	"github.com/isayme/go-config"
@@ -9,14 +12,180 @@ import (
 type AlipanConfig struct {
 	RootDir string `json:"rootDir" yaml:"rootDir"` // 根目录
 
-	Readonly bool `json:"readonly" yaml:"readonly"` // 只读模式
+	Drive string `json:"drive" yaml:"drive"` // 挂载的网盘: backup(默认, 备份盘), resource(资源库), all(同时挂载为 /备份盘 和 /资源库, 忽略 rootDir)
+
+	Mounts []MountConfig `json:"mounts" yaml:"mounts"` // 多个挂载点, 配置后忽略 rootDir 和 drive
+
+	TrashDir  string `json:"trashDir" yaml:"trashDir"`   // 回收站虚拟文件夹, 如 /.trash, 为空时不启用
+	SearchDir string `json:"searchDir" yaml:"searchDir"` // 搜索虚拟文件夹, 如 /.search, 为空时不启用
+
+	RecentDir  string `json:"recentDir" yaml:"recentDir"`   // 最近文件虚拟文件夹, 如 /.recent, 为空时不启用
+	StarredDir string `json:"starredDir" yaml:"starredDir"` // 收藏虚拟文件夹, 如 /.starred, 为空时不启用
+
+	DeleteRules        []DeleteRule `json:"deleteRules" yaml:"deleteRules"`               // 删除策略, 按顺序匹配, 未匹配时移到回收站
+	MaxRecursiveDelete int          `json:"maxRecursiveDelete" yaml:"maxRecursiveDelete"` // 删除文件夹时其中的文件超过该数量则拒绝删除, 0 表示不限制
+
+	JunkFiles JunkFileConfig `json:"junkFiles" yaml:"junkFiles"` // 系统生成的文件(.DS_Store 等)的处理方式
+
+	VideoPreviews []string `json:"videoPreviews" yaml:"videoPreviews"` // 视频转码播放列表的分辨率, 如 720p, 为空时不启用
+	ThumbnailDir  string   `json:"thumbnailDir" yaml:"thumbnailDir"`   // 每个文件夹下的缩略图虚拟文件夹名, 如 .thumbs, 为空时不启用, 同名的网盘文件夹将无法访问
+
+	DirIndex *bool `json:"dirIndex" yaml:"dirIndex"` // 浏览器访问文件夹时显示文件列表
+
+	Readonly *bool `json:"readonly" yaml:"readonly"` // 只读模式
 
 	ClientId     string `json:"clientId" yaml:"clientId"`
 	ClientSecret string `json:"clientSecret" yaml:"clientSecret"`
+
+	OauthRedirectUri string `json:"oauthRedirectUri" yaml:"oauthRedirectUri"` // 授权登录回调地址, 默认为 http(s)://<访问地址>/-/oauth/callback
+
+	LoginTimeout      int `json:"loginTimeout" yaml:"loginTimeout"`           // 等待扫码登录的超时时间(秒), 0 表示不限制
+	QrCodeMaxAttempts int `json:"qrCodeMaxAttempts" yaml:"qrCodeMaxAttempts"` // 二维码过期后最多生成的次数, 0 表示不限制
+
+	AlertWebhook string `json:"alertWebhook" yaml:"alertWebhook"` // token 连续刷新失败时推送告警, 请求体 {"text": "..."}
+}
+
+// DeleteRule 删除策略
+type DeleteRule struct {
+	Pattern string `json:"pattern" yaml:"pattern"` // glob 规则, 不含 / 时匹配文件名, 否则匹配完整路径, 以 /** 结尾时匹配整个文件夹
+	Action  string `json:"action" yaml:"action"`   // trash(默认, 移到回收站), delete(彻底删除), deny(禁止删除)
+}
+
+// JunkFileConfig 系统文件处理方式
+type JunkFileConfig struct {
+	Action   string   `json:"action" yaml:"action"`     // reject(拒绝新建), swallow(假装成功, 丢弃内容), local(保存在本地数据库), 为空时不处理
+	Patterns []string `json:"patterns" yaml:"patterns"` // 匹配规则同删除策略, 默认为 .DS_Store, ._*, Thumbs.db, desktop.ini
+}
+
+// MountConfig 挂载点, 将网盘中的文件夹挂载到 webdav 中的路径
+type MountConfig struct {
+	Path     string `json:"path" yaml:"path"`         // webdav 中的路径, 如 /photos
+	Drive    string `json:"drive" yaml:"drive"`       // 网盘: backup(默认), resource
+	RootDir  string `json:"rootDir" yaml:"rootDir"`   // 网盘中的文件夹, 如 /我的相册/2023
+	Readonly bool   `json:"readonly" yaml:"readonly"` // 只读
+}
+
+const (
+	LOCK_STORE_BOLT   = "bolt"
+	LOCK_STORE_MEMORY = "memory"
+)
+
+type LockConfig struct {
+	Store string `json:"store" yaml:"store"` // 锁存储方式: bolt(默认, 持久化到 db 文件), memory
+}
+
+type TokenStoreConfig struct {
+	Type    string            `json:"type" yaml:"type"`       // token 存储方式: bolt(默认), file, http
+	Path    string            `json:"path" yaml:"path"`       // file 方式的文件路径
+	Url     string            `json:"url" yaml:"url"`         // http 方式的接口地址
+	Headers map[string]string `json:"headers" yaml:"headers"` // http 方式的请求头, 如认证信息
+}
+
+// AccountConfig 多账号时单个账号的配置
+type AccountConfig struct {
+	Name         string       `json:"name" yaml:"name"`     // 账号名称, 账号挂载在 /<name>/ 下, 只能包含字母、数字、- 和 _
+	AlipanConfig AlipanConfig `json:"alipan" yaml:"alipan"` // 未配置的 clientId、clientSecret 等使用全局 alipan 配置
 }
 
 type Config struct {
+	DataDir string `json:"dataDir" yaml:"dataDir"` // 数据目录, 保存 db.db 等文件
+
+	TokenKeyFile string `json:"tokenKeyFile" yaml:"tokenKeyFile"` // token 加密密钥文件
+
 	AlipanConfig AlipanConfig `json:"alipan" yaml:"alipan"`
+	LockConfig   LockConfig   `json:"lock" yaml:"lock"`
+
+	Accounts []AccountConfig `json:"accounts" yaml:"accounts"` // 多账号, 配置后每个账号挂载在 /<name>/ 下
+
+	TokenStoreConfig TokenStoreConfig `json:"tokenStore" yaml:"tokenStore"`
+}
+
+var accountNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// GetAccounts 多账号配置, 账号未配置的通用项使用全局 alipan 配置
+func (conf *Config) GetAccounts() ([]AccountConfig, error) {
+	global := conf.AlipanConfig
+
+	seen := map[string]bool{}
+	accounts := make([]AccountConfig, len(conf.Accounts))
+	for idx, account := range conf.Accounts {
+		if !accountNameRegexp.MatchString(account.Name) {
+			return nil, fmt.Errorf("账号名称 '%s' 无效, 只能包含字母、数字、- 和 _", account.Name)
+		}
+		if seen[account.Name] {
+			return nil, fmt.Errorf("账号名称 '%s' 重复", account.Name)
+		}
+		seen[account.Name] = true
+
+		c := account.AlipanConfig
+		if c.ClientId == "" {
+			c.ClientId = global.ClientId
+			c.ClientSecret = global.ClientSecret
+		}
+		if c.LoginTimeout == 0 {
+			c.LoginTimeout = global.LoginTimeout
+		}
+		if c.QrCodeMaxAttempts == 0 {
+			c.QrCodeMaxAttempts = global.QrCodeMaxAttempts
+		}
+		if c.AlertWebhook == "" {
+			c.AlertWebhook = global.AlertWebhook
+		}
+		if c.DeleteRules == nil {
+			c.DeleteRules = global.DeleteRules
+		}
+		if c.MaxRecursiveDelete == 0 {
+			c.MaxRecursiveDelete = global.MaxRecursiveDelete
+		}
+		if c.JunkFiles.Action == "" {
+			c.JunkFiles = global.JunkFiles
+		}
+		if c.VideoPreviews == nil {
+			c.VideoPreviews = global.VideoPreviews
+		}
+		if c.ThumbnailDir == "" {
+			c.ThumbnailDir = global.ThumbnailDir
+		}
+		if c.RecentDir == "" {
+			c.RecentDir = global.RecentDir
+		}
+		if c.StarredDir == "" {
+			c.StarredDir = global.StarredDir
+		}
+		if c.TrashDir == "" {
+			c.TrashDir = global.TrashDir
+		}
+		if c.SearchDir == "" {
+			c.SearchDir = global.SearchDir
+		}
+		if c.OauthRedirectUri == "" {
+			c.OauthRedirectUri = accountOauthRedirectUri(global.OauthRedirectUri, account.Name)
+		}
+		if c.DirIndex == nil {
+			c.DirIndex = global.DirIndex
+		}
+		if c.Readonly == nil {
+			c.Readonly = global.Readonly
+		}
+
+		accounts[idx] = AccountConfig{Name: account.Name, AlipanConfig: c}
+	}
+
+	return accounts, nil
+}
+
+// accountOauthRedirectUri 账号使用全局的回调地址时, 在 /-/ 前插入账号路径 /<name>
+func accountOauthRedirectUri(uri string, name string) string {
+	idx := strings.LastIndex(uri, INTERNAL_PATH_PREFIX)
+	if idx < 0 {
+		return uri
+	}
+	return uri[:idx] + "/" + name + uri[idx:]
+}
+
+// boolValue 未配置时为 false
+func boolValue(b *bool) bool {
+	return b != nil && *b
 }
 
 var globalConfig = Config{}
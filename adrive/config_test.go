@@ -0,0 +1,68 @@
+package adrive
+
+import "testing"
+
+func TestGetAccountsBoolOverrides(t *testing.T) {
+	yes, no := true, false
+
+	conf := &Config{
+		AlipanConfig: AlipanConfig{Readonly: &yes, DirIndex: &yes},
+		Accounts: []AccountConfig{
+			{Name: "inherit"},
+			{Name: "off", AlipanConfig: AlipanConfig{Readonly: &no, DirIndex: &no}},
+		},
+	}
+
+	accounts, err := conf.GetAccounts()
+	if err != nil {
+		t.Fatalf("GetAccounts() error: %v", err)
+	}
+
+	tests := []struct {
+		readonly bool
+		dirIndex bool
+	}{
+		{true, true},
+		{false, false},
+	}
+	for i, tt := range tests {
+		c := accounts[i].AlipanConfig
+		if boolValue(c.Readonly) != tt.readonly || boolValue(c.DirIndex) != tt.dirIndex {
+			t.Errorf("account %s: readonly = %v, dirIndex = %v, want %v, %v", accounts[i].Name, boolValue(c.Readonly), boolValue(c.DirIndex), tt.readonly, tt.dirIndex)
+		}
+	}
+}
+
+func TestGetAccountsInheritsDirs(t *testing.T) {
+	conf := &Config{
+		AlipanConfig: AlipanConfig{
+			RecentDir:        "/.recent",
+			StarredDir:       "/.starred",
+			TrashDir:         "/.trash",
+			SearchDir:        "/.search",
+			OauthRedirectUri: "https://example.com/dav/-/oauth/callback",
+		},
+		Accounts: []AccountConfig{
+			{Name: "alice"},
+			{Name: "bob", AlipanConfig: AlipanConfig{TrashDir: "/回收站", OauthRedirectUri: "https://bob.example.com/-/oauth/callback"}},
+		},
+	}
+
+	accounts, err := conf.GetAccounts()
+	if err != nil {
+		t.Fatalf("GetAccounts() error: %v", err)
+	}
+
+	alice := accounts[0].AlipanConfig
+	if alice.RecentDir != "/.recent" || alice.StarredDir != "/.starred" || alice.TrashDir != "/.trash" || alice.SearchDir != "/.search" {
+		t.Errorf("alice dirs = %q, %q, %q, %q", alice.RecentDir, alice.StarredDir, alice.TrashDir, alice.SearchDir)
+	}
+	if alice.OauthRedirectUri != "https://example.com/dav/alice/-/oauth/callback" {
+		t.Errorf("alice oauthRedirectUri = %q", alice.OauthRedirectUri)
+	}
+
+	bob := accounts[1].AlipanConfig
+	if bob.TrashDir != "/回收站" || bob.OauthRedirectUri != "https://bob.example.com/-/oauth/callback" {
+		t.Errorf("bob trashDir = %q, oauthRedirectUri = %q", bob.TrashDir, bob.OauthRedirectUri)
+	}
+}
@@ -0,0 +1,81 @@
+package adrive
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const encryptedValuePrefix = "enc:v1:"
+
+const keyDerivationIterations = 100000
+
+var ErrWrongTokenKey = fmt.Errorf("token 解密失败, 请检查密钥是否正确")
+var ErrTokenKeyRequired = fmt.Errorf("token 已加密, 请提供密钥")
+
+// TokenCipher 使用 AES-GCM 加解密 token, 密钥由口令经 PBKDF2-SHA256 派生
+type TokenCipher struct {
+	aead cipher.AEAD
+}
+
+func NewTokenCipher(passphrase string, salt []byte) (*TokenCipher, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("密钥不能为空")
+	}
+
+	key := pbkdf2.Key([]byte(passphrase), salt, keyDerivationIterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenCipher{aead: aead}, nil
+}
+
+func isEncryptedValue(v string) bool {
+	return strings.HasPrefix(v, encryptedValuePrefix)
+}
+
+func (c *TokenCipher) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *TokenCipher) Decrypt(v string) (string, error) {
+	if !isEncryptedValue(v) {
+		return "", fmt.Errorf("不是加密数据")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(v, encryptedValuePrefix))
+	if err != nil {
+		return "", err
+	}
+
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", ErrWrongTokenKey
+	}
+
+	plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", ErrWrongTokenKey
+	}
+
+	return string(plaintext), nil
+}
@@ -0,0 +1,85 @@
+package adrive
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenCipherRoundTrip(t *testing.T) {
+	c, err := NewTokenCipher("secret", []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("NewTokenCipher() error: %v", err)
+	}
+
+	for _, plaintext := range []string{"", "refresh-token", "中文 token"} {
+		encrypted, err := c.Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt(%q) error: %v", plaintext, err)
+		}
+		if !strings.HasPrefix(encrypted, "enc:v1:") || !isEncryptedValue(encrypted) {
+			t.Errorf("Encrypt(%q) = %q, want prefix enc:v1:", plaintext, encrypted)
+		}
+
+		decrypted, err := c.Decrypt(encrypted)
+		if err != nil {
+			t.Fatalf("Decrypt() error: %v", err)
+		}
+		if decrypted != plaintext {
+			t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+		}
+	}
+}
+
+func TestTokenCipherRandomNonce(t *testing.T) {
+	c, err := NewTokenCipher("secret", []byte("salt"))
+	if err != nil {
+		t.Fatalf("NewTokenCipher() error: %v", err)
+	}
+
+	a, _ := c.Encrypt("token")
+	b, _ := c.Encrypt("token")
+	if a == b {
+		t.Errorf("Encrypt() returned the same ciphertext twice: %q", a)
+	}
+}
+
+func TestTokenCipherDecryptError(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	c, _ := NewTokenCipher("secret", salt)
+	encrypted, _ := c.Encrypt("token")
+
+	wrongKey, _ := NewTokenCipher("other", salt)
+	wrongSalt, _ := NewTokenCipher("secret", []byte("fedcba9876543210"))
+
+	tests := []struct {
+		name    string
+		cipher  *TokenCipher
+		value   string
+		wantErr error
+	}{
+		{"密钥错误", wrongKey, encrypted, ErrWrongTokenKey},
+		{"盐错误", wrongSalt, encrypted, ErrWrongTokenKey},
+		{"数据被篡改", c, encrypted[:len(encrypted)-4] + "AAA=", ErrWrongTokenKey},
+		{"数据过短", c, "enc:v1:AAAA", ErrWrongTokenKey},
+		{"未加密", c, "token", nil},
+		{"base64 错误", c, "enc:v1:!!!", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.cipher.Decrypt(tt.value)
+			if err == nil {
+				t.Fatalf("Decrypt(%q) error = nil", tt.value)
+			}
+			if tt.wantErr != nil && err != tt.wantErr {
+				t.Errorf("Decrypt(%q) error = %v, want %v", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewTokenCipherEmptyPassphrase(t *testing.T) {
+	if _, err := NewTokenCipher("", []byte("salt")); err == nil {
+		t.Errorf("NewTokenCipher(\"\") error = nil")
+	}
+}
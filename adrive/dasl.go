@@ -0,0 +1,282 @@
+package adrive
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+)
+
+// 支持 RFC 5323 DASL 的 basicsearch
+const (
+	METHOD_SEARCH     = "SEARCH"
+	DASL_BASIC_SEARCH = "<DAV:basicsearch>"
+)
+
+// 请求体大小上限
+const maxSearchRequestSize = 64 * 1024
+
+// 搜索结果返回的属性
+var searchProps = []xml.Name{
+	{Space: "DAV:", Local: "displayname"},
+	{Space: "DAV:", Local: "resourcetype"},
+	{Space: "DAV:", Local: "getcontentlength"},
+	{Space: "DAV:", Local: "getcontenttype"},
+	{Space: "DAV:", Local: "getlastmodified"},
+}
+
+type daslProp struct {
+	Props []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+func (prop *daslProp) names() []xml.Name {
+	var names []xml.Name
+	for _, p := range prop.Props {
+		names = append(names, p.XMLName)
+	}
+	return names
+}
+
+// daslExpr where 中的条件, 如 and, like, gt, is-collection
+type daslExpr struct {
+	XMLName  xml.Name
+	Prop     daslProp   `xml:"prop"`
+	Literal  string     `xml:"literal"`
+	Children []daslExpr `xml:",any"`
+}
+
+type daslSearchRequest struct {
+	XMLName     xml.Name `xml:"searchrequest"`
+	BasicSearch *struct {
+		Select struct {
+			Prop    daslProp  `xml:"prop"`
+			AllProp *struct{} `xml:"allprop"`
+		} `xml:"select"`
+		From struct {
+			Scope struct {
+				Href  string `xml:"href"`
+				Depth string `xml:"depth"`
+			} `xml:"scope"`
+		} `xml:"from"`
+		Where daslExpr `xml:"where"`
+		Limit struct {
+			NResults int `xml:"nresults"`
+		} `xml:"limit"`
+	} `xml:"basicsearch"`
+}
+
+// applyTo 将条件转为搜索条件, 只支持 and 组合的文件名、修改时间和是否为文件夹
+func (expr *daslExpr) applyTo(q *searchQuery) error {
+	props := expr.Prop.names()
+	prop := ""
+	if len(props) == 1 && props[0].Space == "DAV:" {
+		prop = props[0].Local
+	}
+
+	switch op := expr.XMLName.Local; {
+	case op == "where" || op == "and":
+		for i := range expr.Children {
+			if err := expr.Children[i].applyTo(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	case op == "is-collection":
+		q.fileType = alipanopen.FILE_TYPE_FOLDER
+		return nil
+	case op == "not" && len(expr.Children) == 1 && expr.Children[0].XMLName.Local == "is-collection":
+		q.fileType = alipanopen.FILE_TYPE_FILE
+		return nil
+	case (op == "like" || op == "eq") && prop == "displayname":
+		q.name = strings.TrimSpace(strings.NewReplacer("%", " ", "_", " ").Replace(expr.Literal))
+		return nil
+	case op == "contains":
+		q.name = strings.TrimSpace(expr.Literal)
+		return nil
+	case (op == "gt" || op == "gte" || op == "lt" || op == "lte") && prop == "getlastmodified":
+		t, err := http.ParseTime(expr.Literal)
+		if err != nil {
+			t, err = time.Parse(time.RFC3339, expr.Literal)
+		}
+		if err != nil {
+			return fmt.Errorf("时间格式错误: %s", expr.Literal)
+		}
+		if op == "gt" || op == "gte" {
+			q.after = t
+		} else {
+			q.before = t
+		}
+		return nil
+	default:
+		return fmt.Errorf("不支持的搜索条件: %s %s", op, prop)
+	}
+}
+
+// serveSearch 处理 SEARCH 请求, 返回 scope 下匹配的文件
+func (fs *FileSystem) serveSearch(w http.ResponseWriter, r *http.Request) {
+	req := &daslSearchRequest{}
+	err := xml.NewDecoder(io.LimitReader(r.Body, maxSearchRequestSize)).Decode(req)
+	if err != nil || req.BasicSearch == nil {
+		http.Error(w, "只支持 DAV:basicsearch", http.StatusUnprocessableEntity)
+		return
+	}
+	search := req.BasicSearch
+
+	q := &searchQuery{}
+	if err := search.Where.applyTo(q); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if *q == (searchQuery{}) {
+		http.Error(w, "搜索条件不能为空", http.StatusUnprocessableEntity)
+		return
+	}
+
+	scope := "/"
+	if href := search.From.Scope.Href; href != "" {
+		u, err := url.Parse(href)
+		if err != nil || !strings.HasPrefix(u.Path+"/", fs.homePath()) {
+			http.Error(w, "搜索范围无效: "+href, http.StatusBadRequest)
+			return
+		}
+		scope = fs.resolve(strings.TrimPrefix(u.Path, fs.urlPrefix))
+	}
+	depth := search.From.Scope.Depth
+
+	limit := search.Limit.NResults
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+
+	props := searchProps
+	if search.Select.AllProp == nil && len(search.Select.Prop.Props) > 0 {
+		props = search.Select.Prop.names()
+	}
+
+	results, err := fs.search(r.Context(), q)
+	if err != nil {
+		logger.Errorf("搜索 '%s' 失败: %v", q.openApiQuery(), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<D:multistatus xmlns:D="DAV:">`)
+	count := 0
+	for _, result := range results {
+		if count >= limit {
+			break
+		}
+
+		inScope := scope == "/" || strings.HasPrefix(result.name, scope+"/")
+		if depth == "1" {
+			inScope = path.Dir(result.name) == scope
+		} else if depth == "0" {
+			inScope = false
+		}
+		if !inScope {
+			continue
+		}
+
+		fs.writeSearchResponse(&sb, result, props)
+		count++
+	}
+	sb.WriteString(`</D:multistatus>`)
+
+	logger.Infof("搜索 '%s' 成功, 共有 %d 个结果", q.openApiQuery(), count)
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(sb.String()))
+}
+
+func (fs *FileSystem) writeSearchResponse(sb *strings.Builder, result *resolvedFile, props []xml.Name) {
+	fi := NewFileInfo(result.file, fs.mountFileMode(fs.findMount(result.name)))
+
+	href := fs.urlPrefix + result.name
+	if fi.IsDir() {
+		href = href + "/"
+	}
+
+	sb.WriteString(`<D:response><D:href>`)
+	xml.EscapeText(sb, []byte((&url.URL{Path: href}).EscapedPath()))
+	sb.WriteString(`</D:href><D:propstat><D:prop>`)
+
+	var missing []xml.Name
+	for _, prop := range props {
+		if prop.Space != "DAV:" {
+			missing = append(missing, prop)
+			continue
+		}
+
+		value := ""
+		switch prop.Local {
+		case "displayname":
+			value = fi.Name()
+		case "resourcetype":
+			if fi.IsDir() {
+				sb.WriteString(`<D:resourcetype><D:collection/></D:resourcetype>`)
+			} else {
+				sb.WriteString(`<D:resourcetype/>`)
+			}
+			continue
+		case "getcontentlength":
+			if fi.IsDir() {
+				missing = append(missing, prop)
+				continue
+			}
+			value = strconv.FormatInt(fi.Size(), 10)
+		case "getcontenttype":
+			if fi.IsDir() {
+				missing = append(missing, prop)
+				continue
+			}
+			value = mime.TypeByExtension(path.Ext(fi.Name()))
+			if value == "" {
+				value = "application/octet-stream"
+			}
+		case "getlastmodified":
+			value = fi.ModTime().UTC().Format(http.TimeFormat)
+		default:
+			missing = append(missing, prop)
+			continue
+		}
+
+		sb.WriteString(`<D:` + prop.Local + `>`)
+		xml.EscapeText(sb, []byte(value))
+		sb.WriteString(`</D:` + prop.Local + `>`)
+	}
+	sb.WriteString(`</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>`)
+
+	if len(missing) > 0 {
+		sb.WriteString(`<D:propstat><D:prop>`)
+		for _, prop := range missing {
+			if prop.Space == "DAV:" {
+				sb.WriteString(`<D:` + prop.Local + `/>`)
+			} else {
+				fmt.Fprintf(sb, `<x:%s xmlns:x="%s"/>`, prop.Local, escapeXmlAttr(prop.Space))
+			}
+		}
+		sb.WriteString(`</D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>`)
+	}
+
+	sb.WriteString(`</D:response>`)
+}
+
+func escapeXmlAttr(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
@@ -1,71 +1,418 @@
 package adrive
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"github.com/boltdb/bolt"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
 )
 
-const dbFilePath = "./db.db"
+const dbFileName = "db.db"
 const bucketName = "alipan"
-const refreshTokenKey = "refreshToken"
+const lockBucketName = "locks"
+const localFileBucketName = "localFiles"
+const refreshTokenKey = "refreshToken" // 旧版本只保存 refreshToken
+const tokenKey = "token"
+const tokenLeaseKey = "tokenLease"
+const tokenKeySaltKey = "tokenKeySalt"
+
+// DB 本地数据库, 保存 refreshToken、webdav 锁等信息
+type DB struct {
+	db *bolt.DB
+
+	// 不为空时 refreshToken 加密存储
+	cipher *TokenCipher
+}
+
+// OpenDB 打开(不存在时创建)数据目录下的数据库文件
+func OpenDB(dataDir string) (*DB, error) {
+	if dataDir == "" {
+		dataDir = "."
+	}
+
+	err := os.MkdirAll(dataDir, 0700)
+	if err != nil {
+		return nil, errors.Wrapf(err, "创建数据目录 '%s' 失败", dataDir)
+	}
+
+	dbFilePath := filepath.Join(dataDir, dbFileName)
+	db, err := bolt.Open(dbFilePath, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrapf(err, "打开数据库 '%s' 失败", dbFilePath)
+	}
 
-func init() {
-	err := createBucketIfNotExist()
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{bucketName, lockBucketName, localFileBucketName} {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, errors.Wrapf(err, "初始化数据库 '%s' 失败", dbFilePath)
 	}
+
+	return &DB{db: db}, nil
 }
 
-func createBucketIfNotExist() error {
-	db, err := bolt.Open(dbFilePath, 0600, nil)
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// EnableEncryption 启用 token 加密存储, 已有的明文 token 会被加密后写回
+func (db *DB) EnableEncryption(passphrase string) error {
+	var salt []byte
+
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+
+		if v := b.Get([]byte(tokenKeySaltKey)); v != nil {
+			salt = append(salt, v...)
+			return nil
+		}
+
+		salt = make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		return b.Put([]byte(tokenKeySaltKey), salt)
+	})
 	if err != nil {
 		return err
 	}
 
-	defer db.Close()
+	cipher, err := NewTokenCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
 
-	return db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+	secretKeys, err := db.secretKeys()
+	if err != nil {
 		return err
+	}
+
+	plaintexts := map[string]string{}
+	for _, key := range secretKeys {
+		v, err := db.readValue(key)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			continue
+		}
+
+		if isEncryptedValue(v) {
+			// 校验密钥
+			if _, err := cipher.Decrypt(v); err != nil {
+				return err
+			}
+		} else {
+			plaintexts[key] = v
+		}
+	}
+
+	db.cipher = cipher
+
+	for key, v := range plaintexts {
+		if err := db.writeSecret(key, v); err != nil {
+			return errors.Wrap(err, "加密已有 token 失败")
+		}
+		logger.Infof("已将明文 %s 加密存储", key)
+	}
+
+	return nil
+}
+
+// isSecretKey 需要加密的 key: 旧版本的 refreshToken, token 和多账号的 token:<账号名>
+func isSecretKey(key string) bool {
+	return key == refreshTokenKey || key == tokenKey || strings.HasPrefix(key, tokenKey+":")
+}
+
+func (db *DB) secretKeys() ([]string, error) {
+	var keys []string
+
+	err := db.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucketName)).ForEach(func(k, v []byte) error {
+			if isSecretKey(string(k)) {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
 	})
+
+	return keys, err
 }
 
-func readRefreshToken() (string, error) {
-	db, err := bolt.Open(dbFilePath, 0600, nil)
+func (db *DB) readValue(key string) (string, error) {
+	var value string
+
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+
+		v := b.Get([]byte(key))
+		if v != nil {
+			value = string(v)
+		}
+
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
-	defer db.Close()
 
-	var refreshToken string
+	return value, nil
+}
 
-	err = db.View(func(tx *bolt.Tx) error {
+func (db *DB) writeValue(key, value string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName))
 
-		v := b.Get([]byte(refreshTokenKey))
-		if v != nil {
-			refreshToken = string(v)
+		return b.Put([]byte(key), []byte(value))
+	})
+}
+
+func (db *DB) deleteValue(key string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// readSecret 读取敏感数据, 启用加密时自动解密
+func (db *DB) readSecret(key string) (string, error) {
+	v, err := db.readValue(key)
+	if err != nil {
+		return "", err
+	}
+
+	return db.decodeSecret(key, v)
+}
+
+// writeSecret 写入敏感数据, 启用加密时自动加密
+func (db *DB) writeSecret(key, value string) error {
+	v, err := db.encodeSecret(value)
+	if err != nil {
+		return err
+	}
+
+	return db.writeValue(key, v)
+}
+
+func (db *DB) decodeSecret(key, v string) (string, error) {
+	if !isEncryptedValue(v) {
+		if v != "" && db.cipher == nil {
+			logger.Warnf("%s 以明文存储, 建议配置密钥启用加密", key)
+		}
+		return v, nil
+	}
+
+	if db.cipher == nil {
+		return "", ErrTokenKeyRequired
+	}
+
+	return db.cipher.Decrypt(v)
+}
+
+func (db *DB) encodeSecret(value string) (string, error) {
+	if db.cipher == nil {
+		return value, nil
+	}
+
+	return db.cipher.Encrypt(value)
+}
+
+// localFile 保存在本地数据库中的小文件
+type localFile struct {
+	Data    []byte    `json:"data"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// getLocalFile 读取本地文件, 不存在时返回 nil
+func (db *DB) getLocalFile(key string) (*localFile, error) {
+	var file *localFile
+
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(localFileBucketName))
+
+		v := b.Get([]byte(key))
+		if v == nil {
+			return nil
 		}
 
+		file = &localFile{}
+		return json.Unmarshal(v, file)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (db *DB) putLocalFile(key string, file *localFile) error {
+	v, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(localFileBucketName))
+
+		return b.Put([]byte(key), v)
+	})
+}
+
+// listLocalFiles 列出 key 以 prefix 开头的本地文件
+func (db *DB) listLocalFiles(prefix string) (map[string]*localFile, error) {
+	files := map[string]*localFile{}
+
+	err := db.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(localFileBucketName)).Cursor()
+
+		for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+			file := &localFile{}
+			if err := json.Unmarshal(v, file); err != nil {
+				return err
+			}
+			files[string(k)] = file
+		}
 		return nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (db *DB) deleteLocalFile(key string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(localFileBucketName))
+
+		return b.Delete([]byte(key))
+	})
+}
+
+// moveLocalFiles 将 key 以 oldPrefix 开头的本地文件改为以 newPrefix 开头, 用于移动文件夹
+func (db *DB) moveLocalFiles(oldPrefix, newPrefix string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(localFileBucketName))
+
+		values := map[string][]byte{}
+		c := b.Cursor()
+		for k, v := c.Seek([]byte(oldPrefix)); k != nil && strings.HasPrefix(string(k), oldPrefix); k, v = c.Next() {
+			values[string(k)] = append([]byte(nil), v...)
+		}
+
+		for key := range values {
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		for key, v := range values {
+			if err := b.Put([]byte(newPrefix+strings.TrimPrefix(key, oldPrefix)), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteLocalFiles 删除 key 以 prefix 开头的本地文件, 用于删除文件夹
+func (db *DB) deleteLocalFiles(prefix string) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(localFileBucketName))
+
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		for _, key := range keys {
+			if err := b.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var _ LockStore = &BoltLockStore{}
+
+// BoltLockStore 将锁信息保存在本地数据库中, name 不为空时用于区分多个账号
+type BoltLockStore struct {
+	db     *DB
+	bucket string
+}
+
+func NewBoltLockStore(db *DB, name string) *BoltLockStore {
+	bucket := lockBucketName
+	if name != "" {
+		bucket = lockBucketName + ":" + name
 	}
 
-	return refreshToken, nil
+	return &BoltLockStore{db: db, bucket: bucket}
 }
 
-func writeRefreshToken(refreshToken string) error {
-	db, err := bolt.Open(dbFilePath, 0600, nil)
+func (store *BoltLockStore) ListLocks() ([]*LockRecord, error) {
+	var records []*LockRecord
+
+	err := store.db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(store.bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			record := &LockRecord{}
+			if err := json.Unmarshal(v, record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (store *BoltLockStore) PutLock(record *LockRecord) error {
+	v, err := json.Marshal(record)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
+	return store.db.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(store.bucket))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(record.Token), v)
+	})
+}
+
+func (store *BoltLockStore) DeleteLock(token string) error {
+	return store.db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(store.bucket))
+		if b == nil {
+			return nil
+		}
 
-		return b.Put([]byte(refreshTokenKey), []byte(refreshToken))
+		return b.Delete([]byte(token))
 	})
-	return err
 }
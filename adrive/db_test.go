@@ -0,0 +1,133 @@
+package adrive
+
+import (
+	"testing"
+)
+
+func TestIsSecretKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{refreshTokenKey, true},
+		{tokenKey, true},
+		{tokenKey + ":alice", true},
+		{tokenLeaseKey, false},
+		{tokenLeaseKey + ":alice", false},
+		{tokenKeySaltKey, false},
+	}
+
+	for _, tt := range tests {
+		if got := isSecretKey(tt.key); got != tt.want {
+			t.Errorf("isSecretKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestEnableEncryptionMigratesAccountTokens(t *testing.T) {
+	dataDir := t.TempDir()
+
+	db, err := OpenDB(dataDir)
+	if err != nil {
+		t.Fatalf("OpenDB() error: %v", err)
+	}
+
+	values := map[string]string{
+		tokenKey:                 `{"refreshToken":"default"}`,
+		tokenKey + ":alice":      `{"refreshToken":"alice"}`,
+		tokenLeaseKey + ":alice": `{"holder":"a"}`,
+	}
+	for key, v := range values {
+		if err := db.writeValue(key, v); err != nil {
+			t.Fatalf("writeValue(%q) error: %v", key, err)
+		}
+	}
+
+	if err := db.EnableEncryption("secret"); err != nil {
+		t.Fatalf("EnableEncryption() error: %v", err)
+	}
+
+	for key, want := range values {
+		raw, _ := db.readValue(key)
+		if isSecretKey(key) != isEncryptedValue(raw) {
+			t.Errorf("%q encrypted = %v, want %v", key, isEncryptedValue(raw), isSecretKey(key))
+		}
+
+		got, err := db.readSecret(key)
+		if err != nil {
+			t.Fatalf("readSecret(%q) error: %v", key, err)
+		}
+		if got != want {
+			t.Errorf("readSecret(%q) = %q, want %q", key, got, want)
+		}
+	}
+	db.Close()
+
+	db, err = OpenDB(dataDir)
+	if err != nil {
+		t.Fatalf("OpenDB() error: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.EnableEncryption("other"); err != ErrWrongTokenKey {
+		t.Errorf("EnableEncryption() with wrong key error = %v, want %v", err, ErrWrongTokenKey)
+	}
+}
+
+func TestListLocalFiles(t *testing.T) {
+	db, err := OpenDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDB() error: %v", err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"d1:/a/.DS_Store", "d1:/a/b/.DS_Store", "d1:/ab/.DS_Store", "d2:/a/.DS_Store"} {
+		if err := db.putLocalFile(key, &localFile{Data: []byte(key)}); err != nil {
+			t.Fatalf("putLocalFile(%q) error: %v", key, err)
+		}
+	}
+
+	files, err := db.listLocalFiles("d1:/a/")
+	if err != nil {
+		t.Fatalf("listLocalFiles() error: %v", err)
+	}
+	if len(files) != 2 || files["d1:/a/.DS_Store"] == nil || files["d1:/a/b/.DS_Store"] == nil {
+		t.Errorf("listLocalFiles() = %v", files)
+	}
+}
+
+func TestMoveAndDeleteLocalFiles(t *testing.T) {
+	db, err := OpenDB(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDB() error: %v", err)
+	}
+	defer db.Close()
+
+	for _, key := range []string{"d1:/a/.DS_Store", "d1:/a/b/.DS_Store", "d1:/ab/.DS_Store"} {
+		if err := db.putLocalFile(key, &localFile{Data: []byte(key)}); err != nil {
+			t.Fatalf("putLocalFile(%q) error: %v", key, err)
+		}
+	}
+
+	if err := db.moveLocalFiles("d1:/a/", "d1:/c/"); err != nil {
+		t.Fatalf("moveLocalFiles() error: %v", err)
+	}
+	files, err := db.listLocalFiles("d1:/")
+	if err != nil {
+		t.Fatalf("listLocalFiles() error: %v", err)
+	}
+	if len(files) != 3 || files["d1:/c/.DS_Store"] == nil || string(files["d1:/c/b/.DS_Store"].Data) != "d1:/a/b/.DS_Store" || files["d1:/ab/.DS_Store"] == nil {
+		t.Errorf("files after moveLocalFiles() = %v", files)
+	}
+
+	if err := db.deleteLocalFiles("d1:/c/"); err != nil {
+		t.Fatalf("deleteLocalFiles() error: %v", err)
+	}
+	files, err = db.listLocalFiles("d1:/")
+	if err != nil {
+		t.Fatalf("listLocalFiles() error: %v", err)
+	}
+	if len(files) != 1 || files["d1:/ab/.DS_Store"] == nil {
+		t.Errorf("files after deleteLocalFiles() = %v", files)
+	}
+}
@@ -0,0 +1,192 @@
+package adrive
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+)
+
+const (
+	DELETE_ACTION_TRASH  = "trash"
+	DELETE_ACTION_DELETE = "delete"
+	DELETE_ACTION_DENY   = "deny"
+)
+
+type explicitDeleteKey struct{}
+
+// WithExplicitDelete 标记为明确的删除请求(webdav DELETE 或 rm 命令).
+// x/net/webdav 在 MOVE/COPY 覆盖已有文件时也会调用 RemoveAll, 回收站中的文件只能通过明确的删除请求彻底删除.
+func WithExplicitDelete(ctx context.Context) context.Context {
+	return context.WithValue(ctx, explicitDeleteKey{}, true)
+}
+
+func isExplicitDelete(ctx context.Context) bool {
+	v, _ := ctx.Value(explicitDeleteKey{}).(bool)
+	return v
+}
+
+// deleteAction 按顺序匹配删除策略, 未匹配时移到回收站
+func (fs *FileSystem) deleteAction(name string) string {
+	for _, rule := range fs.deleteRules {
+		if matchPattern(rule.Pattern, name) {
+			if rule.Action == "" {
+				return DELETE_ACTION_TRASH
+			}
+			return rule.Action
+		}
+	}
+
+	return DELETE_ACTION_TRASH
+}
+
+// checkDeleteRules 检查删除策略配置
+func checkDeleteRules(rules []DeleteRule) error {
+	for _, rule := range rules {
+		switch rule.Action {
+		case "", DELETE_ACTION_TRASH, DELETE_ACTION_DELETE, DELETE_ACTION_DENY:
+		default:
+			return fmt.Errorf("删除策略 '%s' 的动作无效: %s", rule.Pattern, rule.Action)
+		}
+		if err := checkPattern(rule.Pattern); err != nil {
+			return fmt.Errorf("删除策略%v", err)
+		}
+	}
+	return nil
+}
+
+// maxDenyCheckFiles 未限制删除数量时, 检查文件夹中是否有禁止删除的文件最多列举的文件数
+const maxDenyCheckFiles = 10000
+
+// canDenyUnder 是否有禁止删除的规则可能匹配文件夹 name 下的文件.
+// 不含 / 的规则匹配文件名, 可能匹配任意文件; 含 / 的规则只有前几级与 name 匹配时才可能匹配.
+func (fs *FileSystem) canDenyUnder(name string) bool {
+	var nameParts []string
+	if name = strings.Trim(name, "/"); name != "" {
+		nameParts = strings.Split(name, "/")
+	}
+
+	for _, rule := range fs.deleteRules {
+		if rule.Action != DELETE_ACTION_DENY {
+			continue
+		}
+		if !strings.Contains(rule.Pattern, "/") {
+			return true
+		}
+
+		recursive := strings.HasSuffix(rule.Pattern, "/**")
+		patternParts := strings.Split(strings.Trim(strings.TrimSuffix(rule.Pattern, "/**"), "/"), "/")
+		if !recursive && len(patternParts) <= len(nameParts) {
+			continue
+		}
+
+		matched := true
+		for i := 0; i < len(patternParts) && i < len(nameParts); i++ {
+			if ok, _ := path.Match(patternParts[i], nameParts[i]); !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFile 按删除策略删除文件. overwrite 为 true 表示上传时覆盖旧文件, 此时禁止删除的文件改为移到回收站.
+func (fs *FileSystem) removeFile(ctx context.Context, name string, file *FileInfo, overwrite bool) error {
+	action := fs.deleteAction(name)
+	if action == DELETE_ACTION_DENY {
+		if !overwrite {
+			logger.Warnf("删除策略禁止删除 '%s'", name)
+			return os.ErrPermission
+		}
+		action = DELETE_ACTION_TRASH
+	}
+
+	// 删除文件夹时其中禁止删除的文件也会被删除, 只检查可能匹配禁止规则的子文件夹, 且最多列举 maxDenyCheckFiles 个文件
+	checkDeny := !overwrite && file.IsDir() && fs.canDenyUnder(name)
+	if file.IsDir() && (fs.maxRecursiveDelete > 0 || checkDeny) {
+		limit := fs.maxRecursiveDelete
+		if checkDeny && (limit <= 0 || limit > maxDenyCheckFiles) {
+			limit = maxDenyCheckFiles
+		}
+
+		count, denied, err := fs.countFiles(ctx, name, file, limit, checkDeny, fs.maxRecursiveDelete > 0)
+		if err != nil {
+			return err
+		}
+		if fs.maxRecursiveDelete > 0 && count > fs.maxRecursiveDelete {
+			return fmt.Errorf("文件夹 '%s' 中的文件超过 %d 个, 拒绝删除", name, fs.maxRecursiveDelete)
+		}
+		if denied != "" {
+			logger.Warnf("删除策略禁止删除 '%s', 拒绝删除文件夹 '%s'", denied, name)
+			return os.ErrPermission
+		}
+		if count > limit {
+			logger.Warnf("文件夹 '%s' 中的文件超过 %d 个, 无法检查删除策略, 拒绝删除", name, limit)
+			return os.ErrPermission
+		}
+	}
+
+	if action == DELETE_ACTION_DELETE {
+		return fs.call(ctx, func(client *alipanopen.Client) error {
+			return client.DeleteFile(ctx, &alipanopen.DeleteFileReq{
+				DriveId: file.DriveId,
+				FileId:  file.FileId,
+			})
+		})
+	}
+
+	err := fs.call(ctx, func(client *alipanopen.Client) error {
+		return client.TrashFile(ctx, &alipanopen.TrashFileReq{
+			DriveId: file.DriveId,
+			FileId:  file.FileId,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.cache.Delete(trashCacheKey)
+	return nil
+}
+
+// countFiles 统计文件夹 name 下的文件和文件夹数量, limit 大于 0 时超过 limit 即停止统计.
+// checkDeny 为 true 时同时查找禁止删除的文件, 找到时停止统计并返回其路径.
+// countAll 为 false 时只用于查找禁止删除的文件, 不进入不可能匹配禁止规则的子文件夹.
+func (fs *FileSystem) countFiles(ctx context.Context, name string, folder *FileInfo, limit int, checkDeny bool, countAll bool) (count int, denied string, err error) {
+	type pending struct {
+		name   string
+		folder *FileInfo
+	}
+
+	folders := []pending{{name, folder}}
+	for len(folders) > 0 && (limit <= 0 || count <= limit) {
+		current := folders[0]
+		folders = folders[1:]
+
+		items, err := fs.listFiles(ctx, current.folder.DriveId, current.folder.FileId)
+		if err != nil {
+			return 0, "", err
+		}
+
+		count = count + len(items)
+		for _, item := range items {
+			itemName := path.Join(current.name, item.FileName)
+			if checkDeny && fs.deleteAction(itemName) == DELETE_ACTION_DENY {
+				return count, itemName, nil
+			}
+			if item.Type == alipanopen.FILE_TYPE_FOLDER && (countAll || fs.canDenyUnder(itemName)) {
+				folders = append(folders, pending{itemName, fs.newFileInfo(item)})
+			}
+		}
+	}
+
+	return count, "", nil
+}
@@ -0,0 +1,198 @@
+package adrive
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/isayme/go-logger"
+)
+
+// dirIndexEntry 文件列表中的文件, 也是 JSON 格式的返回内容
+type dirIndexEntry struct {
+	Name      string    `json:"name"`
+	Url       string    `json:"url"`
+	IsDir     bool      `json:"isDir"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type dirIndexCrumb struct {
+	Name string
+	Url  string
+}
+
+type dirIndex struct {
+	Path  string           `json:"path"`
+	Items []*dirIndexEntry `json:"items"`
+}
+
+var dirIndexTemplate = template.Must(template.New("dirindex").Funcs(template.FuncMap{
+	"size": util.FormatSize,
+	"time": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Path}} - {{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #333; }
+a { color: #0366d6; text-decoration: none; }
+a:hover { text-decoration: underline; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 6px 12px; text-align: left; border-bottom: 1px solid #eee; white-space: nowrap; }
+td.name { white-space: normal; word-break: break-all; width: 100%; }
+td.size { text-align: right; }
+</style>
+</head>
+<body>
+<h2>{{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$c.Url}}">{{$c.Name}}</a>{{end}}</h2>
+<table>
+<tr>
+<th><a href="?sort=name&order={{.NameOrder}}">名称</a></th>
+<th><a href="?sort=size&order={{.SizeOrder}}">大小</a></th>
+<th><a href="?sort=time&order={{.TimeOrder}}">修改时间</a></th>
+<th></th>
+</tr>
+{{if .Parent}}<tr><td class="name"><a href="{{.Parent}}">../</a></td><td></td><td></td><td></td></tr>{{end}}
+{{range .Items}}<tr>
+{{if .IsDir}}<td class="name"><a href="{{.Url}}">{{.Name}}/</a></td><td class="size">-</td><td>{{time .UpdatedAt}}</td><td></td>
+{{else}}<td class="name"><a href="{{.Url}}">{{.Name}}</a></td><td class="size">{{size .Size}}</td><td>{{time .UpdatedAt}}</td><td><a href="{{.Url}}" download>下载</a></td>
+{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// urlPath 文件在 webdav 中的路径对应的 URL
+func (fs *FileSystem) urlPath(name string) string {
+	return (&url.URL{Path: fs.urlPrefix + name}).EscapedPath()
+}
+
+// sortDirIndex 文件夹排在文件前面, by 为 name, size 或 time
+func sortDirIndex(items []*dirIndexEntry, by string, desc bool) {
+	less := func(a, b *dirIndexEntry) bool {
+		switch by {
+		case "size":
+			return a.Size < b.Size
+		case "time":
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		default:
+			return a.Name < b.Name
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+// serveDirIndex 浏览器访问文件夹时返回文件列表, Accept 为 application/json 时返回 JSON.
+// 访问的不是文件夹时返回 false, 由 webdav 处理.
+func (fs *FileSystem) serveDirIndex(w http.ResponseWriter, r *http.Request) bool {
+	ctx := r.Context()
+	name := fs.resolve(strings.TrimPrefix(r.URL.Path, fs.urlPrefix))
+
+	fi, err := fs.Stat(ctx, name)
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+
+	// 保证相对路径正确
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		target := &url.URL{Path: r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+		return true
+	}
+
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	defer f.Close()
+
+	children, err := f.Readdir(-1)
+	if err != nil {
+		logger.Warnf("列举文件夹 '%s' 失败: %v", name, err)
+		http.Error(w, "列举文件夹失败", http.StatusBadGateway)
+		return true
+	}
+
+	index := &dirIndex{Path: name, Items: make([]*dirIndexEntry, 0, len(children))}
+	for _, child := range children {
+		childUrl := fs.urlPath(path.Join(name, child.Name()))
+		if child.IsDir() {
+			childUrl += "/"
+		}
+		index.Items = append(index.Items, &dirIndexEntry{
+			Name:      child.Name(),
+			Url:       childUrl,
+			IsDir:     child.IsDir(),
+			Size:      child.Size(),
+			UpdatedAt: child.ModTime(),
+		})
+	}
+
+	query := r.URL.Query()
+	sortBy, desc := query.Get("sort"), query.Get("order") == "desc"
+	sortDirIndex(index.Items, sortBy, desc)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJson(w, http.StatusOK, index)
+		return true
+	}
+
+	crumbs := []*dirIndexCrumb{{Name: "根目录", Url: fs.homePath()}}
+	p := ""
+	for _, part := range strings.Split(strings.Trim(name, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		p += "/" + part
+		crumbs = append(crumbs, &dirIndexCrumb{Name: part, Url: fs.urlPath(p) + "/"})
+	}
+
+	parent := ""
+	if name != "/" {
+		parent = "../"
+	}
+
+	// 点击当前排序的列时切换顺序
+	order := func(by string) string {
+		if (sortBy == by || (sortBy == "" && by == "name")) && !desc {
+			return "desc"
+		}
+		return "asc"
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	dirIndexTemplate.Execute(w, map[string]interface{}{
+		"Name":      util.Name,
+		"Path":      name,
+		"Crumbs":    crumbs,
+		"Parent":    parent,
+		"Items":     index.Items,
+		"NameOrder": order("name"),
+		"SizeOrder": order("size"),
+		"TimeOrder": order("time"),
+	})
+	return true
+}
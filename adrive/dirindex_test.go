@@ -0,0 +1,47 @@
+package adrive
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSortDirIndex(t *testing.T) {
+	now := time.Now()
+	newItems := func() []*dirIndexEntry {
+		return []*dirIndexEntry{
+			{Name: "b.txt", Size: 1, UpdatedAt: now.Add(2 * time.Hour)},
+			{Name: "dir2", IsDir: true, UpdatedAt: now},
+			{Name: "a.txt", Size: 3, UpdatedAt: now},
+			{Name: "dir1", IsDir: true, UpdatedAt: now.Add(time.Hour)},
+			{Name: "c.txt", Size: 2, UpdatedAt: now.Add(time.Hour)},
+		}
+	}
+
+	tests := []struct {
+		by   string
+		desc bool
+		want []string
+	}{
+		{"name", false, []string{"dir1", "dir2", "a.txt", "b.txt", "c.txt"}},
+		{"name", true, []string{"dir2", "dir1", "c.txt", "b.txt", "a.txt"}},
+		{"", false, []string{"dir1", "dir2", "a.txt", "b.txt", "c.txt"}},
+		{"size", false, []string{"dir2", "dir1", "b.txt", "c.txt", "a.txt"}},
+		{"size", true, []string{"dir2", "dir1", "a.txt", "c.txt", "b.txt"}},
+		{"time", false, []string{"dir2", "dir1", "a.txt", "c.txt", "b.txt"}},
+		{"time", true, []string{"dir1", "dir2", "b.txt", "c.txt", "a.txt"}},
+	}
+
+	for _, tt := range tests {
+		items := newItems()
+		sortDirIndex(items, tt.by, tt.desc)
+
+		var got []string
+		for _, item := range items {
+			got = append(got, item.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortDirIndex(%q, %v) = %v, want %v", tt.by, tt.desc, got, tt.want)
+		}
+	}
+}
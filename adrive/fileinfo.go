@@ -1,10 +1,15 @@
 package adrive
 
 import (
+	"context"
 	"io/fs"
+	"mime"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/isayme/go-alipanopen"
+	"golang.org/x/net/webdav"
 )
 
 var _ fs.FileInfo = &FileInfo{}
@@ -46,6 +51,26 @@ func (f *FileInfo) IsDir() bool {
 	return f.Type == alipanopen.FILE_TYPE_FOLDER
 }
 
+// IsVirtual 是否为虚拟文件或文件夹, 如视频转码播放列表, 没有对应的网盘文件
+func (f *FileInfo) IsVirtual() bool {
+	return f.FileId == ""
+}
+
+// ContentType 虚拟文件按扩展名返回类型, 避免 PROPFIND 时打开文件判断类型而下载播放列表或缩略图
+func (f *FileInfo) ContentType(ctx context.Context) (string, error) {
+	if !f.IsVirtual() || f.IsDir() {
+		return "", webdav.ErrNotImplemented
+	}
+
+	if strings.HasSuffix(f.FileName, hlsPlaylistExt) {
+		return "application/vnd.apple.mpegurl", nil
+	}
+	if ctype := mime.TypeByExtension(path.Ext(f.FileName)); ctype != "" {
+		return ctype, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
 func (f *FileInfo) Sys() any {
 	return nil
 }
@@ -7,10 +7,11 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dghubble/trie"
-	"github.com/isayme/aliyundrive-webdav/util"
 	"github.com/isayme/go-alipanopen"
 	"github.com/isayme/go-logger"
 	"github.com/patrickmn/go-cache"
@@ -21,157 +22,196 @@ import (
 
 const ALIYUNDRIVE_HOST = "https://www.aliyundrive.com"
 
+// getRealFile 缓存文件夹列举结果的时间
+const folderFilesCacheDuration = 10 * time.Second
+
 var _ webdav.FileSystem = &FileSystem{}
 
 type FileSystem struct {
-	rootDir  string
-	rootFile *FileInfo
+	configRootDir string
+	drive         string
+	mountConfigs  []MountConfig
+	mounts        []*mount
+
+	// 回收站和搜索虚拟文件夹, 为空时不启用
+	trashDir  string
+	searchDir string
+
+	// 最近文件和收藏等虚拟文件夹
+	listFolders []*listFolder
+
+	deleteRules        []DeleteRule
+	maxRecursiveDelete int
+
+	junkFiles JunkFileConfig
+
+	// 视频转码播放列表的分辨率, 分片代理地址的签名密钥
+	videoPreviews []string
+	hlsKey        []byte
+
+	// 缩略图虚拟文件夹名, 为空时不启用
+	thumbnailDir string
+	thumbnails   *thumbnailCache
+
+	// 浏览器访问文件夹时显示文件列表
+	dirIndex bool
+
+	ready    int32
+	initLock sync.Mutex
+	login    *loginSession
+
+	oauth         *oauthPending
+	oauthRedirect string
+
+	// 多账号时为 /<账号名>, 由 NewHandler 设置
+	urlPrefix string
+
+	loginTimeout      time.Duration
+	qrCodeMaxAttempts int
 
 	readonly        bool
 	defaultFileMode fs.FileMode
 
-	clientId     string
-	clientSecret string
-	client       *alipanopen.Client
-	fileDriveId  string
+	db     *DB
+	tokens *tokenManager
 
 	cache *cache.Cache
 	root  *trie.PathTrie
 	sg    *singleflight.Group
-
-	refreshToken          string
-	accessToken           string
-	accessTokenExpireTime time.Time
 }
 
-func NewFileSystem(config AlipanConfig) (*FileSystem, error) {
+// NewFileSystem 创建文件系统, 单账号时数据库 db 由文件系统持有, 随 Close 关闭.
+// 没有可用 token 时文件系统处于未登录状态, 需调用 StartLogin 或 LoginWithTerminal 登录.
+func NewFileSystem(config AlipanConfig, db *DB, tokenStore TokenStore) (*FileSystem, error) {
 	ctx := context.Background()
 
-	clientId := config.ClientId
-	clientSecret := config.ClientSecret
-	readonly := config.Readonly
-	rootDir := config.RootDir
-	rootDir = path.Join(rootDir, "/")
-
-	client := alipanopen.NewClient()
-	client.SetRestyClient(restyClient)
+	readonly := boolValue(config.Readonly)
 
 	var defaultFileMode fs.FileMode = 0660
 	if readonly {
 		defaultFileMode = 0440
 	}
 	fs := &FileSystem{
-		clientId:        clientId,
-		clientSecret:    clientSecret,
-		readonly:        readonly,
-		defaultFileMode: defaultFileMode,
+		configRootDir:     path.Join("/", config.RootDir),
+		drive:             config.Drive,
+		mountConfigs:      config.Mounts,
+		trashDir:          virtualDirPath(config.TrashDir),
+		searchDir:         virtualDirPath(config.SearchDir),
+		loginTimeout:      time.Duration(config.LoginTimeout) * time.Second,
+		qrCodeMaxAttempts: config.QrCodeMaxAttempts,
+		oauthRedirect:     config.OauthRedirectUri,
+		readonly:          readonly,
+		defaultFileMode:   defaultFileMode,
+
+		deleteRules:        config.DeleteRules,
+		maxRecursiveDelete: config.MaxRecursiveDelete,
+
+		junkFiles: config.JunkFiles,
+
+		videoPreviews: config.VideoPreviews,
+		hlsKey:        newHlsKey(),
+		thumbnailDir:  config.ThumbnailDir,
+		thumbnails:    newThumbnailCache(maxThumbnailCacheBytes),
+		dirIndex:      boolValue(config.DirIndex),
 
-		client: client,
-		cache:  cache.New(5*time.Minute, 10*time.Minute),
-		root:   trie.NewPathTrie(),
-		sg:     &singleflight.Group{},
+		db:     db,
+		tokens: newTokenManager(config.ClientId, config.ClientSecret, tokenStore, config.AlertWebhook),
+
+		cache: cache.New(5*time.Minute, 10*time.Minute),
+		root:  trie.NewPathTrie(),
+		sg:    &singleflight.Group{},
+		login: &loginSession{ctx: context.Background()},
+		oauth: newOauthPending(),
 	}
 
-	refreshToken, err := readRefreshToken()
+	fs.listFolders = fs.newListFolders(virtualDirPath(config.RecentDir), virtualDirPath(config.StarredDir))
+
+	err := checkDeleteRules(config.DeleteRules)
 	if err != nil {
 		return nil, err
 	}
-	fs.refreshToken = refreshToken
 
-	if refreshToken != "" {
-		reqBody := &alipanopen.RefreshTokenReq{
-			ClientId:     clientId,
-			ClientSecret: clientSecret,
-			RefreshToken: refreshToken,
-			GrantType:    alipanopen.GRANT_TYPE_REFRESH_TOKEN,
-		}
-		refreshTokenResp, err := fs.client.RefreshToken(ctx, reqBody)
-		if err != nil {
-			logger.Warnf("使用 refreshToken 刷新 token 失败: %v", err)
-		} else {
-			fs.saveToken(refreshTokenResp)
-		}
-	}
-
-	err = fs.authIfRequired(ctx)
+	err = checkJunkFileConfig(config.JunkFiles)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := fs.client.GetCurrentUser(ctx)
+	err = checkVideoPreviews(config.VideoPreviews)
 	if err != nil {
 		return nil, err
 	}
-	logger.Infof("认证成功, 当前账号昵称: %s, ID: %s", user.Name, user.Id)
-	driveInfo, err := fs.client.GetDriveInfo(ctx)
+
+	err = fs.tokens.load(ctx)
 	if err != nil {
 		return nil, err
 	}
-	fs.fileDriveId = driveInfo.BackupDriveId
 
-	if rootDir != "/" {
-		reqBody := &alipanopen.GetFileByPathReq{
-			DriveId:  fs.fileDriveId,
-			FilePath: rootDir,
-		}
-		rootFolder, err := fs.client.GetFileByPath(ctx, reqBody)
+	if fs.tokens.hasToken() {
+		err = fs.init(ctx)
 		if err != nil {
 			return nil, err
 		}
-		fs.rootDir = rootDir
-		fs.rootFile = fs.newFileInfo(rootFolder)
-	} else {
-		fs.rootFile = fs.newFileInfo(&alipanopen.File{
-			FileName: util.Name,
-			DriveId:  fs.fileDriveId,
-			FileId:   alipanopen.ROOT_FOLDER_ID,
-			Type:     alipanopen.FILE_TYPE_FOLDER,
-		})
 	}
-	fs.root.Put(rootDir, fs.rootFile)
-
-	fs.startKeepAlive()
 
 	return fs, nil
 }
 
-func (fs *FileSystem) startKeepAlive() {
-	go func() {
-		// 每小时刷新一次 access_token , 以避免长时间无使用导致 access_token、refresh_token 过期.
-		for {
-			time.Sleep(time.Hour)
-
-			reqBody := &alipanopen.RefreshTokenReq{
-				ClientId:     fs.clientId,
-				ClientSecret: fs.clientSecret,
-				RefreshToken: fs.refreshToken,
-				GrantType:    alipanopen.GRANT_TYPE_REFRESH_TOKEN,
-			}
-			refreshTokenResp, err := fs.client.RefreshToken(context.Background(), reqBody)
-			if err != nil {
-				logger.Warnf("自动保活失败: %v", err)
-			} else {
-				logger.Infof("自动保活成功")
-				fs.saveToken(refreshTokenResp)
-				fs.client.SetAccessToken(fs.accessToken)
-			}
-		}
-	}()
+// init 登录后初始化, 获取网盘信息和根目录
+func (fs *FileSystem) init(ctx context.Context) error {
+	fs.initLock.Lock()
+	defer fs.initLock.Unlock()
+
+	if fs.Ready() {
+		return nil
+	}
+
+	var user *alipanopen.GetCurrentUserResp
+	err := fs.call(ctx, func(client *alipanopen.Client) (err error) {
+		user, err = client.GetCurrentUser(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	logger.Infof("认证成功, 当前账号昵称: %s, ID: %s", user.Name, user.Id)
+	var driveInfo *alipanopen.GetDriveInfoResp
+	err = fs.call(ctx, func(client *alipanopen.Client) (err error) {
+		driveInfo, err = client.GetDriveInfo(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	err = fs.initMounts(ctx, driveInfo)
+	if err != nil {
+		return err
+	}
+
+	fs.tokens.start()
+
+	atomic.StoreInt32(&fs.ready, 1)
+
+	return nil
 }
 
-func (fs *FileSystem) saveToken(refreshTokenResp *alipanopen.RefreshTokenResp) {
-	fs.accessToken = refreshTokenResp.AccessToken
-	fs.refreshToken = refreshTokenResp.RefreshToken
-	fs.accessTokenExpireTime = time.Now().Add(time.Second * time.Duration(refreshTokenResp.ExpiresIn))
-	fs.client.SetAccessToken(fs.accessToken)
-	fs.writeRefreshToken(refreshTokenResp.RefreshToken)
+// Ready 是否已登录并完成初始化
+func (fs *FileSystem) Ready() bool {
+	return atomic.LoadInt32(&fs.ready) == 1
 }
 
-func (fs *FileSystem) writeRefreshToken(refreshToken string) {
-	err := writeRefreshToken(refreshToken)
-	if err != nil {
-		logger.Warnf("写 refreshToken 失败: %v", err)
+// Close 关闭数据库. 多账号共用同一个数据库时不要调用, 由打开数据库的一方关闭
+func (fs *FileSystem) Close() error {
+	return fs.db.Close()
+}
+
+// virtualDirPath 虚拟文件夹的路径, 未配置时返回空字符串
+func virtualDirPath(name string) string {
+	name = path.Join("/", name)
+	if name == "/" {
+		return ""
 	}
+	return name
 }
 
 func (fs *FileSystem) cleanTrie(prefix string) {
@@ -185,7 +225,7 @@ func (fs *FileSystem) cleanTrie(prefix string) {
 }
 
 func (fs *FileSystem) resolve(name string) string {
-	return path.Join(fs.rootDir, name)
+	return path.Join("/", name)
 }
 
 func (fs *FileSystem) newFileInfo(file *alipanopen.File) *FileInfo {
@@ -193,24 +233,39 @@ func (fs *FileSystem) newFileInfo(file *alipanopen.File) *FileInfo {
 }
 
 func (fs *FileSystem) getFile(ctx context.Context, name string) (*FileInfo, error) {
-	if name == "" || name == "/" {
-		return fs.rootFile, nil
+	name = fs.resolve(name)
+
+	if fs.isTrashPath(name) {
+		return fs.statTrash(ctx, name)
+	}
+	if fs.isSearchPath(name) {
+		return fs.statSearch(ctx, name)
+	}
+	if folder := fs.findListFolder(name); folder != nil {
+		return fs.statListFolder(ctx, folder, name)
 	}
 
-	file, err := fs.getFileByPath(ctx, fs.fileDriveId, name)
-	if err != nil {
-		return nil, err
+	m := fs.findMount(name)
+	if m == nil {
+		if _, ok := fs.virtualChildren(name); ok {
+			return newVirtualDirInfo(path.Base(name)), nil
+		}
+		return nil, os.ErrNotExist
 	}
 
-	return file, nil
-}
+	if name == m.name {
+		return m.rootFile, nil
+	}
 
-func (fs *FileSystem) checkReadonly() error {
-	if fs.readonly {
-		return os.ErrPermission
+	if fs.isThumbnailPath(name) {
+		return fs.statThumbnail(ctx, name)
 	}
 
-	return nil
+	if fs.isHlsPlaylistName(name) {
+		return fs.statHlsPlaylist(ctx, name)
+	}
+
+	return fs.getFileByPath(ctx, name)
 }
 
 func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) (err error) {
@@ -223,7 +278,7 @@ func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode)
 	}()
 	name = fs.resolve(name)
 
-	if err := fs.checkReadonly(); err != nil {
+	if err := fs.checkWritable(name); err != nil {
 		return err
 	}
 
@@ -239,10 +294,14 @@ func (fs *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode)
 		Name:          path.Base(name),
 		CheckNameMode: alipanopen.CHECK_NAME_MODE_REFUSE,
 	}
-	_, err = fs.client.CreateFolder(ctx, reqBody)
+	err = fs.call(ctx, func(client *alipanopen.Client) error {
+		_, err := client.CreateFolder(ctx, reqBody)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	fs.forgetFolderFiles(parentFolder.DriveId, parentFolder.FileId)
 
 	return nil
 }
@@ -263,8 +322,34 @@ func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm
 		return nil, os.ErrInvalid
 	}
 
+	if fs.isTrashPath(fs.resolve(name)) {
+		return fs.openTrashFile(ctx, fs.resolve(name), flag)
+	}
+	if fs.isSearchPath(fs.resolve(name)) {
+		return fs.openSearchFile(ctx, fs.resolve(name), flag)
+	}
+	if folder := fs.findListFolder(fs.resolve(name)); folder != nil {
+		return fs.openListFolderFile(ctx, folder, fs.resolve(name), flag)
+	}
+
+	if action := fs.junkAction(fs.resolve(name)); action != "" {
+		if flag&os.O_CREATE > 0 {
+			return fs.createJunkFile(fs.resolve(name), action)
+		}
+		if file := fs.getLocalJunkFile(fs.resolve(name)); file != nil {
+			return newMemFile(path.Base(name), file.Data, file.ModTime), nil
+		}
+		if fi := fs.getSwallowedJunkFile(fs.resolve(name)); fi != nil {
+			return newMemFile(path.Base(name), nil, fi.UpdatedAt), nil
+		}
+	}
+
+	if fs.isThumbnailPath(fs.resolve(name)) {
+		return fs.openThumbnail(ctx, fs.resolve(name), flag)
+	}
+
 	if flag&os.O_TRUNC > 0 {
-		err := fs.RemoveAll(ctx, name)
+		err := fs.removeAll(ctx, fs.resolve(name), true)
 		if err != nil && err != os.ErrNotExist {
 			return nil, errors.Wrap(err, "删除源文件失败")
 		}
@@ -273,7 +358,7 @@ func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm
 	name = fs.resolve(name)
 
 	if flag&os.O_CREATE > 0 {
-		if err := fs.checkReadonly(); err != nil {
+		if err := fs.checkWritable(name); err != nil {
 			return nil, err
 		}
 
@@ -284,23 +369,36 @@ func (fs *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm
 			return nil, errors.Wrap(err, "获取父文件夹失败")
 		}
 
-		file := fs.newFileInfo(&alipanopen.File{
+		file := NewFileInfo(&alipanopen.File{
 			FileName:     fileName,
 			ParentFileId: parentFolder.FileId,
 			DriveId:      parentFolder.DriveId,
 			Type:         alipanopen.FILE_TYPE_FILE,
 			UpdatedAt:    time.Now(),
-		})
+		}, parentFolder.fileMode)
 
 		return NewWritableFile(file, fs)
 	}
 
+	if children, ok := fs.virtualChildren(name); ok && fs.findMount(name) == nil {
+		return &virtualDirFile{fi: newVirtualDirInfo(path.Base(name)), children: children}, nil
+	}
+
+	if fs.isHlsPlaylistName(name) {
+		f, err := fs.openHlsPlaylist(ctx, name)
+		if f != nil || err != nil {
+			return f, err
+		}
+	}
+
 	file, err := fs.getFile(ctx, name)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewReadableFile(file, fs), nil
+	f := NewReadableFile(file, fs)
+	f.name = name
+	return f, nil
 }
 
 func (fs *FileSystem) RemoveAll(ctx context.Context, name string) (err error) {
@@ -312,12 +410,26 @@ func (fs *FileSystem) RemoveAll(ctx context.Context, name string) (err error) {
 		}
 	}()
 
-	name = fs.resolve(name)
+	return fs.removeAll(ctx, fs.resolve(name), false)
+}
+
+func (fs *FileSystem) removeAll(ctx context.Context, name string, overwrite bool) error {
+	if fs.isTrashPath(name) {
+		return fs.purgeTrashFile(ctx, name)
+	}
 
-	if err := fs.checkReadonly(); err != nil {
+	if err := fs.checkWritable(name); err != nil {
 		return err
 	}
 
+	if file := fs.getLocalJunkFile(name); file != nil {
+		return fs.db.deleteLocalFile(fs.localJunkKey(name))
+	}
+	if fi := fs.getSwallowedJunkFile(name); fi != nil {
+		fs.cache.Delete(fs.swallowedJunkCacheKey(name))
+		return nil
+	}
+
 	fs.cleanTrie(name)
 
 	file, err := fs.getFile(ctx, name)
@@ -327,12 +439,19 @@ func (fs *FileSystem) RemoveAll(ctx context.Context, name string) (err error) {
 		}
 		return err
 	}
+	if file.IsVirtual() {
+		return os.ErrPermission
+	}
 
-	reqBody := &alipanopen.TrashFileReq{
-		DriveId: file.DriveId,
-		FileId:  file.FileId,
+	if err := fs.removeFile(ctx, name, file, overwrite); err != nil {
+		return err
 	}
-	return fs.client.TrashFile(ctx, reqBody)
+	fs.forgetFolderFiles(file.DriveId, file.ParentFileId)
+
+	if file.IsDir() {
+		fs.removeLocalJunkFiles(name)
+	}
+	return nil
 }
 
 func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) (err error) {
@@ -347,7 +466,21 @@ func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) (err
 	oldName = fs.resolve(oldName)
 	newName = fs.resolve(newName)
 
-	if err := fs.checkReadonly(); err != nil {
+	if fs.isTrashPath(oldName) {
+		return fs.restoreTrashFile(ctx, oldName, newName)
+	}
+
+	if file := fs.getLocalJunkFile(oldName); file != nil {
+		return fs.renameLocalJunkFile(ctx, file, oldName, newName)
+	}
+	if fi := fs.getSwallowedJunkFile(oldName); fi != nil {
+		return fs.renameSwallowedJunkFile(fi, oldName, newName)
+	}
+
+	if err := fs.checkWritable(oldName); err != nil {
+		return err
+	}
+	if err := fs.checkWritable(newName); err != nil {
 		return err
 	}
 
@@ -358,6 +491,9 @@ func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) (err
 	if err != nil {
 		return errors.Wrapf(err, "获取源文件失败")
 	}
+	if sourceFile.IsVirtual() {
+		return os.ErrPermission
+	}
 
 	newFolder := path.Dir(newName)
 	newFileName := path.Base(newName)
@@ -369,7 +505,9 @@ func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) (err
 			Name:          newFileName,
 			CheckNameMode: alipanopen.CHECK_NAME_MODE_REFUSE,
 		}
-		err := fs.client.UpdateFileName(ctx, reqBody)
+		err := fs.call(ctx, func(client *alipanopen.Client) error {
+			return client.UpdateFileName(ctx, reqBody)
+		})
 		if err != nil {
 			return errors.Wrapf(err, "重命名")
 		}
@@ -379,6 +517,10 @@ func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) (err
 			return errors.Wrapf(err, "获取目的父文件夹失败")
 		}
 
+		if newParentFolder.DriveId != sourceFile.DriveId {
+			return ErrCrossDriveMove
+		}
+
 		reqBody := &alipanopen.MoveFileReq{
 			DriveId:        sourceFile.DriveId,
 			FileId:         sourceFile.FileId,
@@ -386,12 +528,19 @@ func (fs *FileSystem) Rename(ctx context.Context, oldName, newName string) (err
 			ToParentFileId: newParentFolder.FileId,
 			CheckNameMode:  alipanopen.CHECK_NAME_MODE_REFUSE,
 		}
-		err = fs.client.MoveFile(ctx, reqBody)
+		fs.forgetFolderFiles(newParentFolder.DriveId, newParentFolder.FileId)
+		err = fs.call(ctx, func(client *alipanopen.Client) error {
+			return client.MoveFile(ctx, reqBody)
+		})
 		if err != nil {
 			return errors.Wrapf(err, "移动失败")
 		}
 	}
 
+	fs.forgetFolderFiles(sourceFile.DriveId, sourceFile.ParentFileId)
+	if sourceFile.IsDir() {
+		fs.moveLocalJunkFiles(oldName, newName)
+	}
 	return nil
 }
 
@@ -406,6 +555,13 @@ func (fs *FileSystem) Stat(ctx context.Context, name string) (fi os.FileInfo, er
 
 	name = fs.resolve(name)
 
+	if file := fs.getLocalJunkFile(name); file != nil {
+		return newMemFileInfo(path.Base(name), int64(len(file.Data)), file.ModTime), nil
+	}
+	if fi := fs.getSwallowedJunkFile(name); fi != nil {
+		return fi, nil
+	}
+
 	file, err := fs.getFile(ctx, name)
 	if err != nil {
 		return nil, err
@@ -441,7 +597,12 @@ func (fs *FileSystem) getDownloadUrl(driveId, fileId, contentHash string) (strin
 		DriveId: driveId,
 		FileId:  fileId,
 	}
-	resp, err := fs.client.GetDownloadUrl(context.Background(), reqBody)
+	ctx := context.Background()
+	var resp *alipanopen.GetFileDownloadUrlResp
+	err := fs.call(ctx, func(client *alipanopen.Client) (err error) {
+		resp, err = client.GetDownloadUrl(ctx, reqBody)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -450,7 +611,7 @@ func (fs *FileSystem) getDownloadUrl(driveId, fileId, contentHash string) (strin
 	return resp.Url, nil
 }
 
-func (fs *FileSystem) getFileByPath(ctx context.Context, driveId, name string) (*FileInfo, error) {
+func (fs *FileSystem) getFileByPath(ctx context.Context, name string) (*FileInfo, error) {
 	if name != "/" {
 		name = strings.TrimRight(name, "/")
 	}
@@ -460,26 +621,21 @@ func (fs *FileSystem) getFileByPath(ctx context.Context, driveId, name string) (
 	}
 
 	dir, fileName := path.Split(name)
-	parent, err := fs.getFileByPath(ctx, driveId, dir)
+	parent, err := fs.getFile(ctx, dir)
 	if err != nil {
 		return nil, err
 	}
 
-	reqBody := &alipanopen.ListFileReq{
-		DriveId:      driveId,
-		ParentFileId: parent.FileId,
-		Limit:        100,
-	}
-	listFileResp, err := fs.client.ListFile(ctx, reqBody)
+	items, err := fs.listFiles(ctx, parent.DriveId, parent.FileId)
 	if err != nil {
 		return nil, err
 	}
 
 	var fi *FileInfo = nil
-	for _, item := range listFileResp.Items {
-		fs.root.Put(path.Join(dir, item.FileName), fs.newFileInfo(item))
+	for _, item := range items {
+		fs.root.Put(path.Join(dir, item.FileName), NewFileInfo(item, parent.fileMode))
 		if item.FileName == fileName {
-			fi = fs.newFileInfo(item)
+			fi = NewFileInfo(item, parent.fileMode)
 		}
 	}
 
@@ -490,19 +646,50 @@ func (fs *FileSystem) getFileByPath(ctx context.Context, driveId, name string) (
 	return fi, nil
 }
 
-func (fs *FileSystem) listDir(ctx context.Context, fi *FileInfo) ([]*FileInfo, error) {
-	result, err, _ := fs.sg.Do(fmt.Sprintf("listDir-%s", fi.FileId), func() (interface{}, error) {
-		reqBody := &alipanopen.ListFileReq{
-			DriveId:      fi.DriveId,
-			ParentFileId: fi.FileId,
-			Limit:        100,
-		}
-		listFileResp, err := fs.client.ListFile(ctx, reqBody)
-		if err != nil {
-			return nil, err
+func folderFilesCacheKey(driveId, folderId string) string {
+	return fmt.Sprintf("folderFiles:%s:%s", driveId, folderId)
+}
+
+// getRealFile 获取网盘中的文件, 用于判断虚拟文件(播放列表、缩略图文件夹)是否与网盘文件重名, 不存在时返回 nil.
+// 父文件夹的列举结果短暂缓存, 避免每个虚拟文件都列举一次, 新建、移动或删除文件时清除.
+func (fs *FileSystem) getRealFile(ctx context.Context, name string) (*FileInfo, error) {
+	if v := fs.root.Get(name); v != nil {
+		return v.(*FileInfo), nil
+	}
+
+	parent, err := fs.getFile(ctx, path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	if parent.IsVirtual() || !parent.IsDir() {
+		return nil, nil
+	}
+
+	result, err := fs.cached(folderFilesCacheKey(parent.DriveId, parent.FileId), folderFilesCacheDuration, func() (interface{}, error) {
+		return fs.listFiles(ctx, parent.DriveId, parent.FileId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range result.([]*alipanopen.File) {
+		if item.FileName == path.Base(name) {
+			fi := NewFileInfo(item, parent.fileMode)
+			fs.root.Put(name, fi)
+			return fi, nil
 		}
+	}
+	return nil, nil
+}
+
+// forgetFolderFiles 文件夹中的文件变化后清除 getRealFile 缓存的列举结果
+func (fs *FileSystem) forgetFolderFiles(driveId, folderId string) {
+	fs.cache.Delete(folderFilesCacheKey(driveId, folderId))
+}
 
-		return listFileResp.Items, nil
+func (fs *FileSystem) listDir(ctx context.Context, fi *FileInfo) ([]*FileInfo, error) {
+	result, err, _ := fs.sg.Do(fmt.Sprintf("listDir-%s", fi.FileId), func() (interface{}, error) {
+		return fs.listFiles(ctx, fi.DriveId, fi.FileId)
 	})
 
 	if err != nil {
@@ -512,7 +699,36 @@ func (fs *FileSystem) listDir(ctx context.Context, fi *FileInfo) ([]*FileInfo, e
 	files := result.([]*alipanopen.File)
 	fis := make([]*FileInfo, len(files))
 	for idx, file := range files {
-		fis[idx] = fs.newFileInfo(file)
+		fis[idx] = NewFileInfo(file, fi.fileMode)
 	}
 	return fis, nil
 }
+
+// listFiles 列举文件夹下的全部文件, 自动翻页
+func (fs *FileSystem) listFiles(ctx context.Context, driveId, parentFileId string) ([]*alipanopen.File, error) {
+	var items []*alipanopen.File
+
+	marker := ""
+	for {
+		reqBody := &alipanopen.ListFileReq{
+			DriveId:      driveId,
+			ParentFileId: parentFileId,
+			Limit:        100,
+			Marker:       marker,
+		}
+		var listFileResp *alipanopen.ListFileResp
+		err := fs.call(ctx, func(client *alipanopen.Client) (err error) {
+			listFileResp, err = client.ListFile(ctx, reqBody)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, listFileResp.Items...)
+		if listFileResp.NextMarker == "" {
+			return items, nil
+		}
+		marker = listFileResp.NextMarker
+	}
+}
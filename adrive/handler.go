@@ -0,0 +1,99 @@
+package adrive
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// 内置页面和接口的路径前缀
+const INTERNAL_PATH_PREFIX = "/-/"
+
+// Handler 在 webdav 服务的基础上提供登录页面等内置接口
+type Handler struct {
+	fs     *FileSystem
+	webdav *webdav.Handler
+	mux    *http.ServeMux
+}
+
+// NewHandler 创建 Handler, prefix 为 URL 前缀, 多账号时为 /<账号名>, 单账号时为空
+func NewHandler(fs *FileSystem, lockSystem webdav.LockSystem, prefix string) *Handler {
+	fs.urlPrefix = prefix
+
+	h := &Handler{
+		fs: fs,
+		webdav: &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: fs,
+			LockSystem: lockSystem,
+		},
+		mux: http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc(fs.internalPath("login"), fs.serveLoginPage)
+	h.mux.HandleFunc(fs.internalPath("login/status"), fs.serveLoginStatus)
+	h.mux.HandleFunc(fs.internalPath("login/qrcode.png"), fs.serveLoginQrCode)
+	h.mux.HandleFunc(fs.internalPath("login/qrcode.svg"), fs.serveLoginQrCode)
+	h.mux.HandleFunc(fs.internalPath("oauth/authorize"), fs.serveOauthAuthorize)
+	h.mux.HandleFunc(fs.internalPath("oauth/callback"), fs.serveOauthCallback)
+	h.mux.HandleFunc(fs.internalPath("share"), fs.serveShare)
+	h.mux.HandleFunc(fs.internalPath("hls/segment"), fs.serveHlsSegment)
+
+	return h
+}
+
+// internalPath 内置页面和接口的完整路径
+func (fs *FileSystem) internalPath(name string) string {
+	return fs.urlPrefix + INTERNAL_PATH_PREFIX + name
+}
+
+// homePath webdav 根目录的 URL 路径
+func (fs *FileSystem) homePath() string {
+	return fs.urlPrefix + "/"
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, h.fs.internalPath("")) {
+		h.mux.ServeHTTP(w, r)
+		return
+	}
+
+	if !h.fs.Ready() {
+		// 浏览器访问跳转到登录页面, 其他客户端返回 503
+		loginPath := h.fs.internalPath("login")
+		if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, loginPath, http.StatusFound)
+			return
+		}
+
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "未登录, 请访问 "+loginPath+" 扫码登录", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case METHOD_SEARCH:
+		h.fs.serveSearch(w, r)
+		return
+	case http.MethodOptions:
+		w.Header().Set("DASL", DASL_BASIC_SEARCH)
+	case http.MethodDelete:
+		r = r.WithContext(WithExplicitDelete(r.Context()))
+	case http.MethodGet, http.MethodHead:
+		query := r.URL.Query()
+		if query.Has("thumbnail") {
+			h.fs.serveThumbnail(w, r)
+			return
+		}
+		if query.Has("archive") {
+			h.fs.serveArchive(w, r)
+			return
+		}
+		if h.fs.dirIndex && h.fs.serveDirIndex(w, r) {
+			return
+		}
+	}
+
+	h.webdav.ServeHTTP(w, r)
+}
@@ -0,0 +1,421 @@
+package adrive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+const videoPreviewUri = "/adrive/v1.0/openFile/getVideoPreviewPlayInfo"
+
+const hlsPlaylistExt = ".m3u8"
+
+// 转码播放链接的有效期, 播放列表缓存时间需小于该值
+const videoPreviewUrlExpire = 4 * time.Hour
+const hlsPlaylistCacheDuration = 10 * time.Minute
+
+// 分辨率对应的转码模板
+var videoPreviewTemplates = map[string]string{
+	"360p":  "LD",
+	"540p":  "SD",
+	"720p":  "HD",
+	"1080p": "FHD",
+	"1440p": "QHD",
+}
+
+var hlsUriAttrRegexp = regexp.MustCompile(`URI="([^"]*)"`)
+
+type videoPreviewReq struct {
+	DriveId      string `json:"drive_id"`
+	FileId       string `json:"file_id"`
+	Category     string `json:"category"`
+	UrlExpireSec int    `json:"url_expire_sec"`
+}
+
+type videoPreviewResp struct {
+	VideoPreviewPlayInfo struct {
+		LiveTranscodingTaskList []struct {
+			TemplateId string `json:"template_id"`
+			Status     string `json:"status"`
+			Url        string `json:"url"`
+		} `json:"live_transcoding_task_list"`
+	} `json:"video_preview_play_info"`
+}
+
+func checkVideoPreviews(resolutions []string) error {
+	for _, resolution := range resolutions {
+		if _, ok := videoPreviewTemplates[resolution]; !ok {
+			return fmt.Errorf("不支持的转码分辨率: %s, 可选: 360p, 540p, 720p, 1080p, 1440p", resolution)
+		}
+	}
+	return nil
+}
+
+func newHlsKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// parseHlsPlaylistName 解析虚拟播放列表的文件名, 如 movie.mkv.720p.m3u8
+func (fs *FileSystem) parseHlsPlaylistName(name string) (videoName, resolution string, ok bool) {
+	if len(fs.videoPreviews) == 0 || !strings.HasSuffix(name, hlsPlaylistExt) {
+		return "", "", false
+	}
+
+	name = strings.TrimSuffix(name, hlsPlaylistExt)
+	i := strings.LastIndex(name, ".")
+	if i <= 0 {
+		return "", "", false
+	}
+
+	videoName, resolution = name[:i], name[i+1:]
+	return videoName, resolution, containsString(fs.videoPreviews, resolution)
+}
+
+func (fs *FileSystem) isHlsPlaylistName(name string) bool {
+	_, _, ok := fs.parseHlsPlaylistName(name)
+	return ok
+}
+
+func isVideo(fi *FileInfo) bool {
+	return !fi.IsDir() && fi.Category == "video"
+}
+
+func hlsPlaylistName(video *FileInfo, resolution string) string {
+	return video.FileName + "." + resolution + hlsPlaylistExt
+}
+
+// newHlsPlaylistInfo 虚拟播放列表没有文件 ID, 不能修改或删除
+func newHlsPlaylistInfo(video *FileInfo, resolution string, size int64) *FileInfo {
+	return NewFileInfo(&alipanopen.File{
+		FileName:  hlsPlaylistName(video, resolution),
+		FileSize:  size,
+		Type:      alipanopen.FILE_TYPE_FILE,
+		CreatedAt: video.CreatedAt,
+		UpdatedAt: video.UpdatedAt,
+	}, 0440)
+}
+
+// hlsPlaylistInfos 文件夹中视频文件的虚拟播放列表, 与已有文件重名时不显示.
+// 只显示已下载过的播放列表, 大小与查看文件信息时一致; 其余的在后台按文件夹获取, 避免列举时每个视频都调用接口.
+func (fs *FileSystem) hlsPlaylistInfos(files []*FileInfo) []os.FileInfo {
+	if len(fs.videoPreviews) == 0 {
+		return nil
+	}
+
+	names := map[string]bool{}
+	for _, file := range files {
+		names[file.FileName] = true
+	}
+
+	var result []os.FileInfo
+	var pending []*FileInfo
+	for _, file := range files {
+		if !isVideo(file) {
+			continue
+		}
+
+		urls, ok := fs.cachedVideoPreviewUrls(file)
+		if !ok {
+			pending = append(pending, file)
+			continue
+		}
+
+		fetched := true
+		for _, resolution := range fs.videoPreviews {
+			if urls[videoPreviewTemplates[resolution]] == "" || names[hlsPlaylistName(file, resolution)] {
+				continue
+			}
+			data, ok := fs.cachedHlsPlaylist(file, resolution)
+			if !ok {
+				fetched = false
+				continue
+			}
+			result = append(result, newHlsPlaylistInfo(file, resolution, int64(len(data))))
+		}
+		if !fetched {
+			pending = append(pending, file)
+		}
+	}
+
+	if len(pending) > 0 {
+		go fs.prefetchHlsPlaylists(pending)
+	}
+	return result
+}
+
+func videoPreviewCacheKey(video *FileInfo) string {
+	return fmt.Sprintf("videoPreview:%s:%s", video.DriveId, video.FileId)
+}
+
+func hlsPlaylistCacheKey(video *FileInfo, resolution string) string {
+	return fmt.Sprintf("hls:%s:%s:%s", video.DriveId, video.FileId, resolution)
+}
+
+// cachedVideoPreviewUrls 已缓存的视频转码信息, 不调用接口
+func (fs *FileSystem) cachedVideoPreviewUrls(video *FileInfo) (map[string]string, bool) {
+	v, ok := fs.cache.Get(videoPreviewCacheKey(video))
+	if !ok {
+		return nil, false
+	}
+	return v.(map[string]string), true
+}
+
+// cachedHlsPlaylist 已缓存的播放列表, 不调用接口
+func (fs *FileSystem) cachedHlsPlaylist(video *FileInfo, resolution string) ([]byte, bool) {
+	v, ok := fs.cache.Get(hlsPlaylistCacheKey(video, resolution))
+	if !ok {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+// prefetchHlsPlaylists 在后台获取同一文件夹中视频的转码信息和播放列表, 同一文件夹同时只获取一次
+func (fs *FileSystem) prefetchHlsPlaylists(videos []*FileInfo) {
+	fs.sg.Do(fmt.Sprintf("hlsPrefetch:%s:%s", videos[0].DriveId, videos[0].ParentFileId), func() (interface{}, error) {
+		ctx := context.Background()
+		for _, video := range videos {
+			urls, err := fs.videoPreviewUrls(ctx, video)
+			if err != nil {
+				logger.Warnf("获取视频 '%s' 的转码信息失败: %v", video.FileName, err)
+				return nil, err
+			}
+
+			for _, resolution := range fs.videoPreviews {
+				if urls[videoPreviewTemplates[resolution]] == "" {
+					continue
+				}
+				if _, err := fs.hlsPlaylist(ctx, video, resolution); err != nil {
+					logger.Warnf("获取视频 '%s' 的 %s 播放列表失败: %v", video.FileName, resolution, err)
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	})
+}
+
+// videoPreviewUrls 视频已完成的转码的播放列表地址, key 为转码模板
+func (fs *FileSystem) videoPreviewUrls(ctx context.Context, video *FileInfo) (map[string]string, error) {
+	result, err := fs.cached(videoPreviewCacheKey(video), hlsPlaylistCacheDuration, func() (interface{}, error) {
+		reqBody := &videoPreviewReq{
+			DriveId:      video.DriveId,
+			FileId:       video.FileId,
+			Category:     "live_transcoding",
+			UrlExpireSec: int(videoPreviewUrlExpire / time.Second),
+		}
+		respBody := &videoPreviewResp{}
+		err := fs.openApiPost(ctx, videoPreviewUri, reqBody, respBody)
+		if err != nil {
+			return nil, errors.Wrapf(err, "获取视频 '%s' 的转码信息失败", video.FileName)
+		}
+
+		urls := map[string]string{}
+		for _, task := range respBody.VideoPreviewPlayInfo.LiveTranscodingTaskList {
+			if task.Status == "finished" {
+				urls[task.TemplateId] = task.Url
+			}
+		}
+		return urls, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(map[string]string), nil
+}
+
+// resolveHlsPlaylist 获取虚拟播放列表对应的视频, 有同名的网盘文件时返回该文件, video 为 nil.
+// 路径缓存中没有时按父文件夹的列举结果判断, 新上传的同名文件不会被虚拟播放列表遮住.
+func (fs *FileSystem) resolveHlsPlaylist(ctx context.Context, name string) (video, file *FileInfo, resolution string, err error) {
+	videoName, resolution, ok := fs.parseHlsPlaylistName(name)
+	if !ok {
+		return nil, nil, "", &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	file, err = fs.getRealFile(ctx, name)
+	if err == os.ErrNotExist {
+		return nil, nil, "", &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if err != nil || file != nil {
+		return nil, file, "", err
+	}
+
+	video, err = fs.getFileByPath(ctx, videoName)
+	if err == os.ErrNotExist || (err == nil && !isVideo(video)) {
+		return nil, nil, "", &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	urls, err := fs.videoPreviewUrls(ctx, video)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if urls[videoPreviewTemplates[resolution]] == "" {
+		logger.Infof("视频 '%s' 没有 %s 的转码", videoName, resolution)
+		return nil, nil, "", &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return video, nil, resolution, nil
+}
+
+// hlsPlaylist 获取视频的转码播放列表, 分片地址改为通过本服务代理
+func (fs *FileSystem) hlsPlaylist(ctx context.Context, video *FileInfo, resolution string) ([]byte, error) {
+	result, err := fs.cached(hlsPlaylistCacheKey(video, resolution), hlsPlaylistCacheDuration, func() (interface{}, error) {
+		urls, err := fs.videoPreviewUrls(ctx, video)
+		if err != nil {
+			return nil, err
+		}
+		return fs.fetchHlsPlaylist(ctx, urls[videoPreviewTemplates[resolution]])
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]byte), nil
+}
+
+func (fs *FileSystem) fetchHlsPlaylist(ctx context.Context, playlistUrl string) ([]byte, error) {
+	resp, err := restyClient.R().SetContext(ctx).SetHeader("Referer", ALIYUNDRIVE_HOST+"/").Get(playlistUrl)
+	if err != nil {
+		return nil, errors.Wrap(err, "下载播放列表失败")
+	}
+	if resp.StatusCode() >= 300 {
+		return nil, fmt.Errorf("下载播放列表失败, 状态码: %d", resp.StatusCode())
+	}
+
+	return fs.rewriteHlsPlaylist(resp.Body(), playlistUrl)
+}
+
+// rewriteHlsPlaylist 将分片地址转为绝对地址后改为本服务的代理地址
+func (fs *FileSystem) rewriteHlsPlaylist(data []byte, playlistUrl string) ([]byte, error) {
+	base, err := url.Parse(playlistUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyUri := func(uri string) string {
+		u, err := base.Parse(uri)
+		if err != nil {
+			return uri
+		}
+		return fs.hlsSegmentUrl(u.String())
+	}
+
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = proxyUri(line)
+		} else if strings.HasPrefix(line, "#") {
+			line = hlsUriAttrRegexp.ReplaceAllStringFunc(line, func(attr string) string {
+				return `URI="` + proxyUri(hlsUriAttrRegexp.FindStringSubmatch(attr)[1]) + `"`
+			})
+		}
+		buf.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (fs *FileSystem) signHlsSegmentUrl(segmentUrl string) string {
+	mac := hmac.New(sha256.New, fs.hlsKey)
+	mac.Write([]byte(segmentUrl))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hlsSegmentUrl 分片的代理地址, 签名防止被用于代理其他地址
+func (fs *FileSystem) hlsSegmentUrl(segmentUrl string) string {
+	query := url.Values{}
+	query.Set("u", segmentUrl)
+	query.Set("s", fs.signHlsSegmentUrl(segmentUrl))
+	return fs.internalPath("hls/segment") + "?" + query.Encode()
+}
+
+// statHlsPlaylist 下载并缓存播放列表以获取实际大小, 下载失败时返回 *os.PathError, 列举文件夹时跳过该文件
+func (fs *FileSystem) statHlsPlaylist(ctx context.Context, name string) (*FileInfo, error) {
+	video, file, resolution, err := fs.resolveHlsPlaylist(ctx, name)
+	if err != nil || file != nil {
+		return file, err
+	}
+
+	data, err := fs.hlsPlaylist(ctx, video, resolution)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return newHlsPlaylistInfo(video, resolution, int64(len(data))), nil
+}
+
+// openHlsPlaylist 有同名的网盘文件时返回 nil
+func (fs *FileSystem) openHlsPlaylist(ctx context.Context, name string) (webdav.File, error) {
+	video, file, resolution, err := fs.resolveHlsPlaylist(ctx, name)
+	if err != nil || file != nil {
+		return nil, err
+	}
+
+	data, err := fs.hlsPlaylist(ctx, video, resolution)
+	if err != nil {
+		return nil, err
+	}
+	return newMemFile(path.Base(name), data, video.ModTime()), nil
+}
+
+// serveHlsSegment 代理转码视频的分片, 支持 Range 请求
+func (fs *FileSystem) serveHlsSegment(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	segmentUrl := query.Get("u")
+	if segmentUrl == "" || !hmac.Equal([]byte(query.Get("s")), []byte(fs.signHlsSegmentUrl(segmentUrl))) {
+		http.Error(w, "分片地址无效", http.StatusForbidden)
+		return
+	}
+
+	headers := map[string]string{
+		alipanopen.HEADER_ACCEPT: "*/*",
+		"Referer":                ALIYUNDRIVE_HOST + "/",
+	}
+	if v := r.Header.Get("Range"); v != "" {
+		headers[alipanopen.HEADER_RANGE] = v
+	}
+
+	resp, err := restyClient.R().SetContext(r.Context()).SetDoNotParseResponse(true).SetHeaders(headers).Get(segmentUrl)
+	if err != nil {
+		logger.Warnf("下载分片失败: %v", err)
+		http.Error(w, "下载分片失败", http.StatusBadGateway)
+		return
+	}
+	rawBody := resp.RawBody()
+	defer rawBody.Close()
+
+	for _, key := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges"} {
+		if v := resp.Header().Get(key); v != "" {
+			w.Header().Set(key, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode())
+	io.Copy(w, rawBody)
+}
@@ -0,0 +1,170 @@
+package adrive
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dghubble/trie"
+	"github.com/isayme/go-alipanopen"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/sync/singleflight"
+)
+
+func TestRewriteHlsPlaylist(t *testing.T) {
+	fs := &FileSystem{hlsKey: []byte("key")}
+
+	playlist := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		`#EXT-X-KEY:METHOD=AES-128,URI="key.bin"`,
+		"#EXTINF:10.0,",
+		"  seg-0.ts  ",
+		"",
+		"#EXTINF:10.0,",
+		"https://cdn.example.com/other/seg-1.ts?x=1",
+		"#EXT-X-ENDLIST",
+	}, "\n")
+
+	data, err := fs.rewriteHlsPlaylist([]byte(playlist), "https://cdn.example.com/v/index.m3u8?auth=abc")
+	if err != nil {
+		t.Fatalf("rewriteHlsPlaylist() error: %v", err)
+	}
+
+	want := []string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		`#EXT-X-KEY:METHOD=AES-128,URI="` + fs.hlsSegmentUrl("https://cdn.example.com/v/key.bin") + `"`,
+		"#EXTINF:10.0,",
+		fs.hlsSegmentUrl("https://cdn.example.com/v/seg-0.ts"),
+		"",
+		"#EXTINF:10.0,",
+		fs.hlsSegmentUrl("https://cdn.example.com/other/seg-1.ts?x=1"),
+		"#EXT-X-ENDLIST",
+	}
+	got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(got) != len(want) {
+		t.Fatalf("rewriteHlsPlaylist() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestHlsSegmentUrl(t *testing.T) {
+	fs := &FileSystem{hlsKey: []byte("key"), urlPrefix: "/alice"}
+
+	u, err := url.Parse(fs.hlsSegmentUrl("https://cdn.example.com/seg.ts"))
+	if err != nil {
+		t.Fatalf("hlsSegmentUrl() error: %v", err)
+	}
+	if u.Path != fs.internalPath("hls/segment") {
+		t.Errorf("hlsSegmentUrl() path = %q, want %q", u.Path, fs.internalPath("hls/segment"))
+	}
+
+	query := u.Query()
+	if query.Get("u") != "https://cdn.example.com/seg.ts" || query.Get("s") != fs.signHlsSegmentUrl(query.Get("u")) {
+		t.Errorf("hlsSegmentUrl() query = %v", query)
+	}
+	if (&FileSystem{hlsKey: []byte("other")}).signHlsSegmentUrl(query.Get("u")) == query.Get("s") {
+		t.Errorf("signHlsSegmentUrl() does not depend on the key")
+	}
+}
+
+func TestParseHlsPlaylistName(t *testing.T) {
+	fs := &FileSystem{videoPreviews: []string{"720p", "1080p"}}
+
+	tests := []struct {
+		name           string
+		wantVideo      string
+		wantResolution string
+		wantOk         bool
+	}{
+		{"/a/movie.mkv.720p.m3u8", "/a/movie.mkv", "720p", true},
+		{"/a/movie.1080p.m3u8", "/a/movie", "1080p", true},
+		{"/a/movie.mkv.360p.m3u8", "", "", false},
+		{"/a/movie.mkv.720p", "", "", false},
+		{"/a/720p.m3u8", "", "", false},
+	}
+
+	for _, tt := range tests {
+		video, resolution, ok := fs.parseHlsPlaylistName(tt.name)
+		if ok != tt.wantOk || (ok && (video != tt.wantVideo || resolution != tt.wantResolution)) {
+			t.Errorf("parseHlsPlaylistName(%q) = %q, %q, %v, want %q, %q, %v", tt.name, video, resolution, ok, tt.wantVideo, tt.wantResolution, tt.wantOk)
+		}
+	}
+}
+
+func TestHlsPlaylistInfosUsesCachedPlaylists(t *testing.T) {
+	fs := &FileSystem{videoPreviews: []string{"720p", "1080p"}, cache: cache.New(time.Minute, time.Minute), sg: &singleflight.Group{}}
+
+	video := func(fileId, name string) *FileInfo {
+		return NewFileInfo(&alipanopen.File{DriveId: "d", FileId: fileId, FileName: name, Category: "video", Type: alipanopen.FILE_TYPE_FILE}, 0)
+	}
+	a := video("a", "a.mkv")
+	b := video("b", "b.mkv")
+	c := video("c", "c.mkv")
+	existing := NewFileInfo(&alipanopen.File{FileId: "x", FileName: "a.mkv.1080p.m3u8", Type: alipanopen.FILE_TYPE_FILE}, 0)
+
+	// a 的 720p 播放列表已下载, 1080p 与已有文件重名; b 没有 720p 和 1080p 的转码; c 的播放列表未下载时不显示
+	fs.cache.Set(videoPreviewCacheKey(a), map[string]string{"HD": "u1", "FHD": "u2"}, time.Minute)
+	fs.cache.Set(videoPreviewCacheKey(b), map[string]string{"LD": "u3"}, time.Minute)
+	fs.cache.Set(videoPreviewCacheKey(c), map[string]string{}, time.Minute)
+	fs.cache.Set(hlsPlaylistCacheKey(a, "720p"), []byte("#EXTM3U\n"), time.Minute)
+
+	infos := fs.hlsPlaylistInfos([]*FileInfo{a, b, c, existing})
+	if len(infos) != 1 || infos[0].Name() != "a.mkv.720p.m3u8" || infos[0].Size() != 8 {
+		t.Errorf("hlsPlaylistInfos() = %v", infos)
+	}
+}
+
+func TestHlsPlaylistCacheKeyIncludesDrive(t *testing.T) {
+	a := NewFileInfo(&alipanopen.File{DriveId: "d1", FileId: "a"}, 0)
+	b := NewFileInfo(&alipanopen.File{DriveId: "d2", FileId: "a"}, 0)
+	if hlsPlaylistCacheKey(a, "720p") == hlsPlaylistCacheKey(b, "720p") {
+		t.Errorf("hlsPlaylistCacheKey() is the same for different drives")
+	}
+}
+
+func TestResolveHlsPlaylistPrefersRealFile(t *testing.T) {
+	fs := &FileSystem{
+		videoPreviews: []string{"720p", "1080p"},
+		cache:         cache.New(time.Minute, time.Minute),
+		root:          trie.NewPathTrie(),
+		sg:            &singleflight.Group{},
+		mounts: []*mount{
+			{name: "/", driveId: "d", rootFile: NewFileInfo(&alipanopen.File{DriveId: "d", FileId: "root", Type: alipanopen.FILE_TYPE_FOLDER}, 0660)},
+		},
+	}
+
+	video := &alipanopen.File{DriveId: "d", FileId: "v", ParentFileId: "root", FileName: "a.mkv", Category: "video", Type: alipanopen.FILE_TYPE_FILE}
+	real := &alipanopen.File{DriveId: "d", FileId: "p", ParentFileId: "root", FileName: "a.mkv.720p.m3u8", Type: alipanopen.FILE_TYPE_FILE, FileSize: 100}
+	fs.root.Put("/a.mkv", NewFileInfo(video, 0660))
+	// 路径缓存中没有新上传的播放列表, 从父文件夹的列举结果中获取
+	fs.cache.Set(folderFilesCacheKey("d", "root"), []*alipanopen.File{video, real}, time.Minute)
+	fs.cache.Set(videoPreviewCacheKey(NewFileInfo(video, 0)), map[string]string{"HD": "u1", "FHD": "u2"}, time.Minute)
+	fs.cache.Set(hlsPlaylistCacheKey(NewFileInfo(video, 0), "1080p"), []byte("#EXTM3U\n"), time.Minute)
+
+	fi, err := fs.statHlsPlaylist(context.Background(), "/a.mkv.720p.m3u8")
+	if err != nil {
+		t.Fatalf("statHlsPlaylist(720p) error: %v", err)
+	}
+	if fi.FileId != "p" || fi.Size() != 100 || fi.IsVirtual() {
+		t.Errorf("statHlsPlaylist(720p) = %+v, want the real file", fi)
+	}
+	if v := fs.root.Get("/a.mkv.720p.m3u8"); v == nil {
+		t.Errorf("real playlist is not cached by path")
+	}
+
+	fi, err = fs.statHlsPlaylist(context.Background(), "/a.mkv.1080p.m3u8")
+	if err != nil {
+		t.Fatalf("statHlsPlaylist(1080p) error: %v", err)
+	}
+	if !fi.IsVirtual() || fi.Size() != 8 {
+		t.Errorf("statHlsPlaylist(1080p) = %+v, want the virtual playlist with its real size", fi)
+	}
+}
@@ -0,0 +1,219 @@
+package adrive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/isayme/go-logger"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	JUNK_ACTION_REJECT  = "reject"
+	JUNK_ACTION_SWALLOW = "swallow"
+	JUNK_ACTION_LOCAL   = "local"
+)
+
+// 未配置时默认过滤的系统文件
+var defaultJunkPatterns = []string{".DS_Store", "._*", "Thumbs.db", "desktop.ini"}
+
+// 保存在本地的系统文件大小上限
+const maxLocalJunkFileSize = 1024 * 1024
+
+// 丢弃的系统文件在内存中保留文件信息的时间, 避免客户端上传后立即查看时报文件不存在
+const swallowedJunkFileDuration = time.Minute
+
+func checkJunkFileConfig(config JunkFileConfig) error {
+	switch config.Action {
+	case "", JUNK_ACTION_REJECT, JUNK_ACTION_SWALLOW, JUNK_ACTION_LOCAL:
+	default:
+		return fmt.Errorf("系统文件处理方式无效: %s", config.Action)
+	}
+
+	for _, pattern := range config.Patterns {
+		if err := checkPattern(pattern); err != nil {
+			return fmt.Errorf("系统文件%v", err)
+		}
+	}
+	return nil
+}
+
+// junkAction name 为系统文件时返回处理方式, 否则返回空字符串
+func (fs *FileSystem) junkAction(name string) string {
+	if fs.junkFiles.Action == "" {
+		return ""
+	}
+
+	patterns := fs.junkFiles.Patterns
+	if len(patterns) == 0 {
+		patterns = defaultJunkPatterns
+	}
+
+	for _, pattern := range patterns {
+		if matchPattern(pattern, name) {
+			return fs.junkFiles.Action
+		}
+	}
+	return ""
+}
+
+// localJunkKey 本地保存的 key, 使用网盘 ID 区分不同账号和网盘
+func (fs *FileSystem) localJunkKey(name string) string {
+	driveId := ""
+	if m := fs.findMount(name); m != nil {
+		driveId = m.driveId
+	}
+	return driveId + ":" + name
+}
+
+// getLocalJunkFile 读取本地保存的系统文件, 不存在时返回 nil
+func (fs *FileSystem) getLocalJunkFile(name string) *localFile {
+	if fs.junkAction(name) != JUNK_ACTION_LOCAL {
+		return nil
+	}
+
+	file, err := fs.db.getLocalFile(fs.localJunkKey(name))
+	if err != nil {
+		logger.Warnf("读取本地文件 '%s' 失败: %v", name, err)
+		return nil
+	}
+	return file
+}
+
+func (fs *FileSystem) swallowedJunkCacheKey(name string) string {
+	return fmt.Sprintf("swallowedJunk-%s", fs.localJunkKey(name))
+}
+
+// getSwallowedJunkFile 读取最近丢弃的系统文件信息, 不存在时返回 nil
+func (fs *FileSystem) getSwallowedJunkFile(name string) *FileInfo {
+	if fs.junkAction(name) != JUNK_ACTION_SWALLOW {
+		return nil
+	}
+
+	v, ok := fs.cache.Get(fs.swallowedJunkCacheKey(name))
+	if !ok {
+		return nil
+	}
+	return v.(*FileInfo)
+}
+
+// localJunkPrefix 文件夹 dir 下本地保存的系统文件 key 的前缀
+func (fs *FileSystem) localJunkPrefix(dir string) string {
+	return strings.TrimSuffix(fs.localJunkKey(path.Join(dir, "_")), "_")
+}
+
+// moveLocalJunkFiles 移动文件夹后, 其中本地保存的系统文件随之移动
+func (fs *FileSystem) moveLocalJunkFiles(oldDir, newDir string) {
+	if fs.junkFiles.Action != JUNK_ACTION_LOCAL {
+		return
+	}
+
+	if err := fs.db.moveLocalFiles(fs.localJunkPrefix(oldDir), fs.localJunkPrefix(newDir)); err != nil {
+		logger.Warnf("移动文件夹 '%s' 中的本地文件失败: %v", oldDir, err)
+	}
+}
+
+// removeLocalJunkFiles 删除文件夹后, 同时删除其中本地保存的系统文件
+func (fs *FileSystem) removeLocalJunkFiles(dir string) {
+	if fs.junkFiles.Action != JUNK_ACTION_LOCAL {
+		return
+	}
+
+	if err := fs.db.deleteLocalFiles(fs.localJunkPrefix(dir)); err != nil {
+		logger.Warnf("删除文件夹 '%s' 中的本地文件失败: %v", dir, err)
+	}
+}
+
+// localJunkInfos 列出文件夹 dir 下保存在本地的系统文件
+func (fs *FileSystem) localJunkInfos(dir string) []os.FileInfo {
+	if fs.junkFiles.Action != JUNK_ACTION_LOCAL {
+		return nil
+	}
+
+	prefix := fs.localJunkPrefix(dir)
+	files, err := fs.db.listLocalFiles(prefix)
+	if err != nil {
+		logger.Warnf("列举本地文件 '%s' 失败: %v", dir, err)
+		return nil
+	}
+
+	var result []os.FileInfo
+	for key, file := range files {
+		fileName := strings.TrimPrefix(key, prefix)
+		if strings.Contains(fileName, "/") || fs.junkAction(path.Join(dir, fileName)) != JUNK_ACTION_LOCAL {
+			continue
+		}
+		result = append(result, newMemFileInfo(fileName, int64(len(file.Data)), file.ModTime))
+	}
+	return result
+}
+
+// createJunkFile 新建系统文件, 按处理方式拒绝、丢弃或保存到本地
+func (fs *FileSystem) createJunkFile(name string, action string) (webdav.File, error) {
+	if err := fs.checkWritable(name); err != nil {
+		return nil, err
+	}
+
+	switch action {
+	case JUNK_ACTION_REJECT:
+		logger.Infof("拒绝新建系统文件 '%s'", name)
+		return nil, os.ErrPermission
+	case JUNK_ACTION_SWALLOW:
+		f := newMemWriteFile(path.Base(name), 0, nil)
+		fs.cache.Set(fs.swallowedJunkCacheKey(name), f.fi, swallowedJunkFileDuration)
+		return f, nil
+	default:
+		key := fs.localJunkKey(name)
+		return newMemWriteFile(path.Base(name), maxLocalJunkFileSize, func(data []byte) error {
+			return fs.db.putLocalFile(key, &localFile{Data: data, ModTime: time.Now()})
+		}), nil
+	}
+}
+
+// renameLocalJunkFile 移动本地保存的系统文件, 目的文件不是系统文件时上传到网盘
+func (fs *FileSystem) renameLocalJunkFile(ctx context.Context, file *localFile, oldName, newName string) error {
+	if err := fs.checkWritable(newName); err != nil {
+		return err
+	}
+
+	if fs.junkAction(newName) == JUNK_ACTION_LOCAL {
+		err := fs.db.putLocalFile(fs.localJunkKey(newName), file)
+		if err != nil {
+			return err
+		}
+		return fs.db.deleteLocalFile(fs.localJunkKey(oldName))
+	}
+
+	f, err := fs.OpenFile(ctx, newName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, newMemFile(path.Base(oldName), file.Data, file.ModTime))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return fs.db.deleteLocalFile(fs.localJunkKey(oldName))
+}
+
+// renameSwallowedJunkFile 移动丢弃的系统文件, 内容已丢弃, 目的文件不是丢弃的系统文件时拒绝
+func (fs *FileSystem) renameSwallowedJunkFile(fi *FileInfo, oldName, newName string) error {
+	if err := fs.checkWritable(newName); err != nil {
+		return err
+	}
+	if fs.junkAction(newName) != JUNK_ACTION_SWALLOW {
+		return os.ErrPermission
+	}
+
+	fs.cache.Delete(fs.swallowedJunkCacheKey(oldName))
+	fs.cache.Set(fs.swallowedJunkCacheKey(newName), newMemFileInfo(path.Base(newName), fi.FileSize, fi.UpdatedAt), swallowedJunkFileDuration)
+	return nil
+}
@@ -0,0 +1,300 @@
+package adrive
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isayme/go-logger"
+	"golang.org/x/net/webdav"
+)
+
+var _ webdav.LockSystem = &LockSystem{}
+
+// LockRecord 持久化的 webdav 锁信息
+type LockRecord struct {
+	Token     string        `json:"token"`
+	Root      string        `json:"root"`
+	Duration  time.Duration `json:"duration"`
+	OwnerXML  string        `json:"ownerXml"`
+	ZeroDepth bool          `json:"zeroDepth"`
+	// 过期时间, 无限期的锁为零值
+	ExpireAt time.Time `json:"expireAt"`
+	// 创建或刷新的时间
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// expired 无限期的锁在当前进程内一直有效, 进程启动前遗留的(如 PUT 时的临时锁)视为已过期,
+// 避免进程异常退出后路径一直被锁定
+func (record *LockRecord) expired(now, startedAt time.Time) bool {
+	if record.ExpireAt.IsZero() {
+		return record.UpdatedAt.Before(startedAt)
+	}
+	return !now.Before(record.ExpireAt)
+}
+
+func lockExpireAt(now time.Time, duration time.Duration) time.Time {
+	if duration < 0 {
+		return time.Time{}
+	}
+	return now.Add(duration)
+}
+
+func (record *LockRecord) details() webdav.LockDetails {
+	return webdav.LockDetails{
+		Root:      record.Root,
+		Duration:  record.Duration,
+		OwnerXML:  record.OwnerXML,
+		ZeroDepth: record.ZeroDepth,
+	}
+}
+
+// covers 判断锁是否作用于 name
+func (record *LockRecord) covers(name string) bool {
+	if name == record.Root {
+		return true
+	}
+	if record.ZeroDepth {
+		return false
+	}
+	return record.Root == "/" || strings.HasPrefix(name, record.Root+"/")
+}
+
+// LockStore 锁信息存储, 实现该接口可使多个实例共享锁
+type LockStore interface {
+	ListLocks() ([]*LockRecord, error)
+	PutLock(record *LockRecord) error
+	DeleteLock(token string) error
+}
+
+// LockSystem 基于 LockStore 的 webdav.LockSystem 实现, 服务重启后锁依然有效.
+// 锁的持有状态(Confirm 到 release 之间)只在当前进程内有效.
+// 无限期的锁只在创建它的进程内有效, 多个实例共享锁时, 其他实例在本实例启动前创建的无限期锁也会失效.
+type LockSystem struct {
+	store     LockStore
+	startedAt time.Time
+
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func NewLockSystem(store LockStore) *LockSystem {
+	return &LockSystem{
+		store:     store,
+		startedAt: time.Now(),
+		held:      map[string]bool{},
+	}
+}
+
+// StartSweep 定期清理过期的锁
+func (ls *LockSystem) StartSweep(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			ls.mu.Lock()
+			_, err := ls.loadLocks(time.Now())
+			ls.mu.Unlock()
+			if err != nil {
+				logger.Warnf("清理过期锁失败: %v", err)
+			}
+		}
+	}()
+}
+
+// loadLocks 读取所有有效的锁, 并删除已过期的锁
+func (ls *LockSystem) loadLocks(now time.Time) ([]*LockRecord, error) {
+	records, err := ls.store.ListLocks()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*LockRecord, 0, len(records))
+	for _, record := range records {
+		if record.expired(now, ls.startedAt) && !ls.held[record.Token] {
+			if err := ls.store.DeleteLock(record.Token); err != nil {
+				return nil, err
+			}
+			logger.Debugf("锁 '%s' 已过期, 路径: %s", record.Token, record.Root)
+			continue
+		}
+		result = append(result, record)
+	}
+
+	return result, nil
+}
+
+func (ls *LockSystem) findLock(records []*LockRecord, token string) *LockRecord {
+	for _, record := range records {
+		if record.Token == token {
+			return record
+		}
+	}
+	return nil
+}
+
+func (ls *LockSystem) lookup(records []*LockRecord, name string, conditions ...webdav.Condition) *LockRecord {
+	for _, c := range conditions {
+		record := ls.findLock(records, c.Token)
+		if record == nil || ls.held[record.Token] {
+			continue
+		}
+		if record.covers(name) {
+			return record
+		}
+	}
+	return nil
+}
+
+func (ls *LockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	records, err := ls.loadLocks(now)
+	if err != nil {
+		return nil, err
+	}
+
+	var r0, r1 *LockRecord
+	if name0 != "" {
+		if r0 = ls.lookup(records, cleanLockName(name0), conditions...); r0 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	if name1 != "" {
+		if r1 = ls.lookup(records, cleanLockName(name1), conditions...); r1 == nil {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+
+	if r1 == r0 {
+		r1 = nil
+	}
+	if r0 != nil {
+		ls.held[r0.Token] = true
+	}
+	if r1 != nil {
+		ls.held[r1.Token] = true
+	}
+
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		if r1 != nil {
+			delete(ls.held, r1.Token)
+		}
+		if r0 != nil {
+			delete(ls.held, r0.Token)
+		}
+	}, nil
+}
+
+func (ls *LockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	records, err := ls.loadLocks(now)
+	if err != nil {
+		return "", err
+	}
+
+	details.Root = cleanLockName(details.Root)
+	for _, record := range records {
+		// 目标或其父目录已被锁定
+		if record.covers(details.Root) {
+			return "", webdav.ErrLocked
+		}
+		// 无限深度锁要求子路径均未被锁定
+		if !details.ZeroDepth && (details.Root == "/" || strings.HasPrefix(record.Root, details.Root+"/")) {
+			return "", webdav.ErrLocked
+		}
+	}
+
+	token, err := genLockToken()
+	if err != nil {
+		return "", err
+	}
+
+	record := &LockRecord{
+		Token:     token,
+		Root:      details.Root,
+		Duration:  details.Duration,
+		OwnerXML:  details.OwnerXML,
+		ZeroDepth: details.ZeroDepth,
+		ExpireAt:  lockExpireAt(now, details.Duration),
+		UpdatedAt: now,
+	}
+
+	if err := ls.store.PutLock(record); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (ls *LockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	records, err := ls.loadLocks(now)
+	if err != nil {
+		return webdav.LockDetails{}, err
+	}
+
+	record := ls.findLock(records, token)
+	if record == nil {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	if ls.held[token] {
+		return webdav.LockDetails{}, webdav.ErrLocked
+	}
+
+	record.Duration = duration
+	record.ExpireAt = lockExpireAt(now, duration)
+	record.UpdatedAt = now
+
+	if err := ls.store.PutLock(record); err != nil {
+		return webdav.LockDetails{}, err
+	}
+
+	return record.details(), nil
+}
+
+func (ls *LockSystem) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	records, err := ls.loadLocks(now)
+	if err != nil {
+		return err
+	}
+
+	if ls.findLock(records, token) == nil {
+		return webdav.ErrNoSuchLock
+	}
+	if ls.held[token] {
+		return webdav.ErrLocked
+	}
+
+	return ls.store.DeleteLock(token)
+}
+
+func cleanLockName(name string) string {
+	if name == "" || name[0] != '/' {
+		name = "/" + name
+	}
+	return path.Clean(name)
+}
+
+func genLockToken() (string, error) {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+
+	s := hex.EncodeToString(bs)
+	return "opaquelocktoken:" + s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:], nil
+}
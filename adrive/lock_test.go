@@ -0,0 +1,168 @@
+package adrive
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+type memLockStore struct {
+	records map[string]*LockRecord
+}
+
+func newMemLockStore() *memLockStore {
+	return &memLockStore{records: map[string]*LockRecord{}}
+}
+
+func (store *memLockStore) ListLocks() ([]*LockRecord, error) {
+	var result []*LockRecord
+	for _, record := range store.records {
+		r := *record
+		result = append(result, &r)
+	}
+	return result, nil
+}
+
+func (store *memLockStore) PutLock(record *LockRecord) error {
+	r := *record
+	store.records[record.Token] = &r
+	return nil
+}
+
+func (store *memLockStore) DeleteLock(token string) error {
+	delete(store.records, token)
+	return nil
+}
+
+func TestLockRecordExpired(t *testing.T) {
+	startedAt := time.Now()
+	now := startedAt.Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		expireAt  time.Time
+		updatedAt time.Time
+		want      bool
+	}{
+		{"未过期", now.Add(time.Second), now, false},
+		{"刚好过期", now, now, true},
+		{"已过期", now.Add(-time.Second), now, true},
+		{"当前进程的无限期锁", time.Time{}, startedAt.Add(time.Second), false},
+		{"进程启动前遗留的无限期锁", time.Time{}, startedAt.Add(-time.Second), true},
+		{"旧版本的无限期锁", time.Time{}, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record := &LockRecord{ExpireAt: tt.expireAt, UpdatedAt: tt.updatedAt}
+			if got := record.expired(now, startedAt); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLockRecordCovers(t *testing.T) {
+	tests := []struct {
+		root      string
+		zeroDepth bool
+		name      string
+		want      bool
+	}{
+		{"/a", false, "/a", true},
+		{"/a", false, "/a/b", true},
+		{"/a", false, "/ab", false},
+		{"/a", true, "/a/b", false},
+		{"/", false, "/a", true},
+	}
+
+	for _, tt := range tests {
+		record := &LockRecord{Root: tt.root, ZeroDepth: tt.zeroDepth}
+		if got := record.covers(tt.name); got != tt.want {
+			t.Errorf("covers(%q, %q) = %v, want %v", tt.root, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLockSystemExpiry(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		after    time.Duration
+		locked   bool
+	}{
+		{"有效期内", time.Minute, 30 * time.Second, true},
+		{"已过期", time.Minute, 2 * time.Minute, false},
+		{"无限期的锁", -1, 24 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemLockStore()
+			ls := NewLockSystem(store)
+			now := time.Now()
+
+			_, err := ls.Create(now, webdav.LockDetails{Root: "/a", Duration: tt.duration})
+			if err != nil {
+				t.Fatalf("Create() error: %v", err)
+			}
+
+			_, err = ls.Create(now.Add(tt.after), webdav.LockDetails{Root: "/a/b", Duration: time.Minute})
+			if locked := err == webdav.ErrLocked; locked != tt.locked {
+				t.Errorf("locked = %v, want %v, err: %v", locked, tt.locked, err)
+			}
+		})
+	}
+}
+
+func TestLockSystemUnlock(t *testing.T) {
+	ls := NewLockSystem(newMemLockStore())
+	now := time.Now()
+
+	token, err := ls.Create(now, webdav.LockDetails{Root: "/a", Duration: time.Minute})
+	if err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+
+	release, err := ls.Confirm(now, "/a/b", "", webdav.Condition{Token: token})
+	if err != nil {
+		t.Fatalf("Confirm() error: %v", err)
+	}
+	if err := ls.Unlock(now, token); err != webdav.ErrLocked {
+		t.Errorf("Unlock() held lock error = %v, want %v", err, webdav.ErrLocked)
+	}
+
+	release()
+	if err := ls.Unlock(now, token); err != nil {
+		t.Errorf("Unlock() error: %v", err)
+	}
+	if err := ls.Unlock(now, token); err != webdav.ErrNoSuchLock {
+		t.Errorf("Unlock() twice error = %v, want %v", err, webdav.ErrNoSuchLock)
+	}
+}
+
+func TestLockSystemRestart(t *testing.T) {
+	store := newMemLockStore()
+	ls := NewLockSystem(store)
+	now := time.Now()
+
+	if _, err := ls.Create(now, webdav.LockDetails{Root: "/a", Duration: -1}); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+	if _, err := ls.Create(now, webdav.LockDetails{Root: "/b", Duration: time.Hour}); err != nil {
+		t.Fatalf("Create() error: %v", err)
+	}
+
+	// 重启后遗留的无限期锁失效, 有过期时间的锁依然有效
+	time.Sleep(time.Millisecond)
+	ls = NewLockSystem(store)
+	now = time.Now()
+
+	if _, err := ls.Create(now, webdav.LockDetails{Root: "/a", Duration: time.Minute}); err != nil {
+		t.Errorf("Create() after restart error: %v", err)
+	}
+	if _, err := ls.Create(now, webdav.LockDetails{Root: "/b", Duration: time.Minute}); err != webdav.ErrLocked {
+		t.Errorf("Create() after restart error = %v, want %v", err, webdav.ErrLocked)
+	}
+}
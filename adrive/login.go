@@ -0,0 +1,241 @@
+package adrive
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"rsc.io/qr"
+)
+
+const (
+	LOGIN_STATUS_READY = "Ready"
+	LOGIN_STATUS_ERROR = "Error"
+)
+
+// loginSession 网页扫码登录状态
+type loginSession struct {
+	mu sync.Mutex
+	// 取消时停止等待扫码
+	ctx context.Context
+
+	running   bool
+	qrCodeUrl string
+	status    string
+	message   string
+	// 每次生成新二维码时加一, 页面据此刷新二维码图片
+	qrCodeVersion int
+}
+
+type loginStatus struct {
+	Status        string `json:"status"`
+	Message       string `json:"message"`
+	QrCodeVersion int    `json:"qrCodeVersion"`
+}
+
+func (session *loginSession) set(fn func(session *loginSession)) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	fn(session)
+}
+
+func (session *loginSession) get() (qrCodeUrl string, status loginStatus) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	return session.qrCodeUrl, loginStatus{
+		Status:        session.status,
+		Message:       session.message,
+		QrCodeVersion: session.qrCodeVersion,
+	}
+}
+
+// StartLogin 在后台开始扫码登录, 二维码同时在终端打印和通过登录页面展示.
+// ctx 取消时停止等待扫码, 之后通过登录页面重新发起的登录也使用该 ctx.
+func (fs *FileSystem) StartLogin(ctx context.Context) {
+	fs.login.set(func(session *loginSession) {
+		session.ctx = ctx
+	})
+
+	fs.startLogin()
+}
+
+// startLogin 已登录或正在登录时不做处理
+func (fs *FileSystem) startLogin() {
+	if fs.Ready() {
+		return
+	}
+
+	session := fs.login
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.ctx.Err() != nil {
+		return
+	}
+	ctx := session.ctx
+
+	if session.running {
+		return
+	}
+	session.running = true
+	session.qrCodeUrl = ""
+	session.status = alipanopen.QRCODE_STATUS_WAITLOGIN
+	session.message = ""
+
+	go func() {
+		err := fs.qrCodeLogin(ctx, func(qrCodeUrl string) {
+			printQrCode(qrCodeUrl)
+			session.set(func(session *loginSession) {
+				session.qrCodeUrl = qrCodeUrl
+				session.qrCodeVersion++
+			})
+		}, func(status string) {
+			session.set(func(session *loginSession) {
+				session.status = status
+				session.message = qrCodeStatusTexts[status]
+			})
+		})
+		if err == nil {
+			err = fs.init(ctx)
+		}
+
+		session.set(func(session *loginSession) {
+			session.running = false
+			if err != nil && ctx.Err() == context.Canceled {
+				logger.Infof("已取消扫码登录")
+				session.status = LOGIN_STATUS_ERROR
+				session.message = "已取消扫码登录"
+			} else if err != nil {
+				logger.Errorf("扫码登录失败: %v", err)
+				session.status = LOGIN_STATUS_ERROR
+				session.message = err.Error()
+			} else {
+				logger.Infof("扫码登录成功, 服务已就绪")
+				session.status = LOGIN_STATUS_READY
+				session.message = "登录成功"
+			}
+		})
+	}()
+}
+
+var loginPageTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Name}} - 登录</title>
+<style>
+body { font-family: sans-serif; text-align: center; margin-top: 60px; color: #333; }
+img { width: 240px; height: 240px; border: 1px solid #eee; }
+#status { margin-top: 16px; }
+</style>
+</head>
+<body>
+<h2>使用阿里云盘 App 扫码登录</h2>
+<img id="qrcode" alt="二维码">
+<div id="status">等待扫码...</div>
+<p><a href="{{.Prefix}}oauth/authorize">或跳转到阿里云盘授权登录</a></p>
+<script>
+var qrCodeVersion = 0
+var timer = setInterval(function () {
+  fetch('{{.Prefix}}login/status').then(function (resp) { return resp.json() }).then(function (data) {
+    var status = document.getElementById('status')
+    status.innerText = data.message || data.status
+    if (data.qrCodeVersion !== qrCodeVersion) {
+      qrCodeVersion = data.qrCodeVersion
+      document.getElementById('qrcode').src = '{{.Prefix}}login/qrcode.svg?v=' + qrCodeVersion
+    }
+    if (data.status === '{{.Ready}}') {
+      clearInterval(timer)
+      location.href = '{{.Home}}'
+    } else if (data.status === '{{.Error}}') {
+      clearInterval(timer)
+      status.innerHTML += ' <a href="{{.Prefix}}login">重试</a>'
+    }
+  })
+}, 1000)
+</script>
+</body>
+</html>
+`))
+
+func (fs *FileSystem) serveLoginPage(w http.ResponseWriter, r *http.Request) {
+	if fs.Ready() {
+		http.Redirect(w, r, fs.homePath(), http.StatusFound)
+		return
+	}
+
+	fs.startLogin()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	loginPageTemplate.Execute(w, map[string]string{
+		"Name":   util.Name,
+		"Prefix": fs.internalPath(""),
+		"Home":   fs.homePath(),
+		"Ready":  LOGIN_STATUS_READY,
+		"Error":  LOGIN_STATUS_ERROR,
+	})
+}
+
+func (fs *FileSystem) serveLoginStatus(w http.ResponseWriter, r *http.Request) {
+	_, status := fs.login.get()
+	if fs.Ready() {
+		status = loginStatus{Status: LOGIN_STATUS_READY, Message: "登录成功"}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(util.Stringify(status)))
+}
+
+func (fs *FileSystem) serveLoginQrCode(w http.ResponseWriter, r *http.Request) {
+	qrCodeUrl, _ := fs.login.get()
+	if qrCodeUrl == "" {
+		http.Error(w, "二维码尚未生成", http.StatusNotFound)
+		return
+	}
+
+	code, err := qr.Encode(qrCodeUrl, qr.L)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if strings.HasSuffix(r.URL.Path, ".png") {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(code.PNG())
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write([]byte(qrCodeSvg(code)))
+}
+
+// qrCodeSvg 将二维码转为 svg, 四周留 2 格空白
+func qrCodeSvg(code *qr.Code) string {
+	const quietZone = 2
+	size := code.Size + quietZone*2
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&sb, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&sb, "M%d %dh1v1h-1z", x+quietZone, y+quietZone)
+			}
+		}
+	}
+	sb.WriteString(`"/></svg>`)
+
+	return sb.String()
+}
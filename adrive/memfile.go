@@ -0,0 +1,106 @@
+package adrive
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"golang.org/x/net/webdav"
+)
+
+// newMemFileInfo 内容不在网盘中的文件信息
+func newMemFileInfo(name string, size int64, modTime time.Time) *FileInfo {
+	return NewFileInfo(&alipanopen.File{
+		FileName:  name,
+		FileSize:  size,
+		Type:      alipanopen.FILE_TYPE_FILE,
+		UpdatedAt: modTime,
+	}, 0440)
+}
+
+var _ webdav.File = &memFile{}
+
+// memFile 内容在内存中的只读文件
+type memFile struct {
+	*bytes.Reader
+	fi fs.FileInfo
+}
+
+func newMemFile(name string, data []byte, modTime time.Time) *memFile {
+	return &memFile{
+		Reader: bytes.NewReader(data),
+		fi:     newMemFileInfo(name, int64(len(data)), modTime),
+	}
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
+
+var _ webdav.File = &memWriteFile{}
+
+// memWriteFile 写入内存的文件, 关闭时调用 onClose 保存内容, onClose 为空时丢弃内容
+type memWriteFile struct {
+	buf     bytes.Buffer
+	fi      *FileInfo
+	maxSize int
+	onClose func(data []byte) error
+}
+
+func newMemWriteFile(name string, maxSize int, onClose func(data []byte) error) *memWriteFile {
+	return &memWriteFile{
+		fi:      newMemFileInfo(name, 0, time.Now()),
+		maxSize: maxSize,
+		onClose: onClose,
+	}
+}
+
+func (f *memWriteFile) Write(p []byte) (int, error) {
+	if f.maxSize > 0 && f.buf.Len()+len(p) > f.maxSize {
+		return 0, fmt.Errorf("文件大小超过限制 %d", f.maxSize)
+	}
+
+	f.fi.FileSize = f.fi.FileSize + int64(len(p))
+	if f.onClose == nil {
+		return len(p), nil
+	}
+	return f.buf.Write(p)
+}
+
+func (f *memWriteFile) Close() error {
+	if f.onClose == nil {
+		return nil
+	}
+	return f.onClose(f.buf.Bytes())
+}
+
+func (f *memWriteFile) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("not support")
+}
+
+func (f *memWriteFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, fmt.Errorf("not support")
+}
+
+func (f *memWriteFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return nil, fmt.Errorf("not support")
+}
+
+func (f *memWriteFile) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
@@ -0,0 +1,309 @@
+package adrive
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	DRIVE_BACKUP   = "backup"
+	DRIVE_RESOURCE = "resource"
+	DRIVE_ALL      = "all"
+)
+
+// 同时挂载两个网盘时的文件夹名
+const (
+	BACKUP_DRIVE_FOLDER   = "备份盘"
+	RESOURCE_DRIVE_FOLDER = "资源库"
+)
+
+var ErrCrossDriveMove = fmt.Errorf("不支持在不同网盘间移动文件, 请先复制再删除")
+
+// mount 挂载点, 将网盘中的文件夹挂载到 webdav 的路径 name 下
+type mount struct {
+	name     string
+	driveId  string
+	rootDir  string
+	readonly bool
+	rootFile *FileInfo
+}
+
+// contains name 是否在挂载点下(含挂载点本身)
+func (m *mount) contains(name string) bool {
+	return m.name == "/" || name == m.name || strings.HasPrefix(name, m.name+"/")
+}
+
+// initMounts 根据配置确定挂载的网盘和目录, 获取各挂载点的根文件夹
+func (fs *FileSystem) initMounts(ctx context.Context, driveInfo *alipanopen.GetDriveInfoResp) error {
+	var mounts []*mount
+	var err error
+
+	if len(fs.mountConfigs) > 0 {
+		mounts, err = newMounts(fs.mountConfigs, driveInfo)
+		if err != nil {
+			return err
+		}
+	} else {
+		switch fs.drive {
+		case DRIVE_RESOURCE:
+			if driveInfo.ResourceDriveId == "" {
+				return fmt.Errorf("当前账号没有资源库")
+			}
+			mounts = append(mounts, &mount{name: "/", driveId: driveInfo.ResourceDriveId, rootDir: fs.configRootDir})
+		case DRIVE_ALL:
+			mounts = append(mounts, &mount{name: "/" + BACKUP_DRIVE_FOLDER, driveId: driveInfo.BackupDriveId, rootDir: "/"})
+			if driveInfo.ResourceDriveId != "" {
+				mounts = append(mounts, &mount{name: "/" + RESOURCE_DRIVE_FOLDER, driveId: driveInfo.ResourceDriveId, rootDir: "/"})
+			} else {
+				logger.Warnf("当前账号没有资源库, 只挂载备份盘")
+			}
+		case DRIVE_BACKUP, "":
+			mounts = append(mounts, &mount{name: "/", driveId: driveInfo.BackupDriveId, rootDir: fs.configRootDir})
+		default:
+			return fmt.Errorf("未知的网盘类型: %s", fs.drive)
+		}
+	}
+
+	for _, m := range mounts {
+		m.readonly = m.readonly || fs.readonly
+
+		rootFile, err := fs.getMountRootFile(ctx, m)
+		if err != nil {
+			return fmt.Errorf("获取挂载点 '%s' 的根目录 '%s' 失败: %v", m.name, m.rootDir, err)
+		}
+		m.rootFile = rootFile
+		logger.Infof("挂载网盘 '%s' 的 '%s' 到 '%s'", m.driveId, m.rootDir, m.name)
+	}
+
+	// 最长的挂载路径优先匹配
+	sort.Slice(mounts, func(i, j int) bool {
+		return len(mounts[i].name) > len(mounts[j].name)
+	})
+	fs.mounts = mounts
+
+	return nil
+}
+
+// newMounts 根据挂载点配置创建挂载点, 挂载点之间不能嵌套
+func newMounts(configs []MountConfig, driveInfo *alipanopen.GetDriveInfoResp) ([]*mount, error) {
+	var mounts []*mount
+	for _, config := range configs {
+		name := path.Join("/", config.Path)
+
+		driveId := driveInfo.BackupDriveId
+		switch config.Drive {
+		case DRIVE_BACKUP, "":
+		case DRIVE_RESOURCE:
+			driveId = driveInfo.ResourceDriveId
+			if driveId == "" {
+				return nil, fmt.Errorf("挂载点 '%s': 当前账号没有资源库", name)
+			}
+		default:
+			return nil, fmt.Errorf("挂载点 '%s': 未知的网盘类型: %s", name, config.Drive)
+		}
+
+		for _, other := range mounts {
+			if other.contains(name) || (&mount{name: name}).contains(other.name) {
+				return nil, fmt.Errorf("挂载点 '%s' 与 '%s' 重复或嵌套", name, other.name)
+			}
+		}
+
+		mounts = append(mounts, &mount{
+			name:     name,
+			driveId:  driveId,
+			rootDir:  path.Join("/", config.RootDir),
+			readonly: config.Readonly,
+		})
+	}
+
+	return mounts, nil
+}
+
+func (fs *FileSystem) getMountRootFile(ctx context.Context, m *mount) (*FileInfo, error) {
+	var rootFolder *alipanopen.File
+	if m.rootDir != "/" {
+		reqBody := &alipanopen.GetFileByPathReq{
+			DriveId:  m.driveId,
+			FilePath: m.rootDir,
+		}
+		err := fs.call(ctx, func(client *alipanopen.Client) (err error) {
+			rootFolder, err = client.GetFileByPath(ctx, reqBody)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rootFolder = &alipanopen.File{
+			DriveId: m.driveId,
+			FileId:  alipanopen.ROOT_FOLDER_ID,
+			Type:    alipanopen.FILE_TYPE_FOLDER,
+		}
+	}
+
+	// 文件名使用挂载路径的名称
+	file := *rootFolder
+	file.FileName = path.Base(m.name)
+	if m.name == "/" {
+		file.FileName = util.Name
+	}
+
+	return NewFileInfo(&file, fs.mountFileMode(m)), nil
+}
+
+// mountFileMode 只读挂载点下的文件显示为只读, 子文件沿用父文件夹的权限
+func (fs *FileSystem) mountFileMode(m *mount) fs.FileMode {
+	if m != nil && m.readonly {
+		return 0440
+	}
+	return fs.defaultFileMode
+}
+
+// mountedDriveIds 已挂载的网盘
+func (fs *FileSystem) mountedDriveIds() []string {
+	seen := map[string]bool{}
+	var driveIds []string
+	for _, m := range fs.mounts {
+		if !seen[m.driveId] {
+			seen[m.driveId] = true
+			driveIds = append(driveIds, m.driveId)
+		}
+	}
+	return driveIds
+}
+
+// findMount 返回 name 所在的挂载点, 不在任何挂载点下时返回 nil
+func (fs *FileSystem) findMount(name string) *mount {
+	for _, m := range fs.mounts {
+		if m.contains(name) {
+			return m
+		}
+	}
+	return nil
+}
+
+// virtualChildren name 为虚拟文件夹(挂载点的上级文件夹)时返回其下的文件夹
+func (fs *FileSystem) virtualChildren(name string) ([]fs.FileInfo, bool) {
+	prefix := strings.TrimSuffix(name, "/") + "/"
+
+	seen := map[string]bool{}
+	var children []os.FileInfo
+	for _, m := range fs.mounts {
+		if m.name == "/" || !strings.HasPrefix(m.name, prefix) {
+			continue
+		}
+
+		childName := strings.SplitN(strings.TrimPrefix(m.name, prefix), "/", 2)[0]
+		if seen[childName] {
+			continue
+		}
+		seen[childName] = true
+
+		if childPath := prefix + childName; childPath == m.name {
+			children = append(children, m.rootFile)
+		} else {
+			children = append(children, newVirtualDirInfo(childName))
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+	return children, len(children) > 0
+}
+
+func newVirtualDirInfo(name string) *FileInfo {
+	return NewFileInfo(&alipanopen.File{
+		FileName: name,
+		Type:     alipanopen.FILE_TYPE_FOLDER,
+	}, 0440)
+}
+
+// uniqueFileNames 虚拟文件夹中的文件可能来自不同文件夹, key 为显示的文件名, 重名时文件名后加上文件 ID
+func uniqueFileNames(items []*alipanopen.File) map[string]*alipanopen.File {
+	count := map[string]int{}
+	for _, item := range items {
+		count[item.FileName]++
+	}
+
+	files := map[string]*alipanopen.File{}
+	for _, item := range items {
+		name := item.FileName
+		if count[name] > 1 {
+			name = fmt.Sprintf("%s~%s", name, item.FileId)
+		}
+		files[name] = item
+	}
+	return files
+}
+
+// isVirtualPath name 是否在回收站、搜索、最近文件、收藏或缩略图虚拟文件夹下
+func (fs *FileSystem) isVirtualPath(name string) bool {
+	return fs.isTrashPath(name) || fs.isSearchPath(name) || fs.findListFolder(name) != nil || fs.isThumbnailPath(name)
+}
+
+// checkWritable 检查是否可以新建、修改或删除 name, 挂载点本身、虚拟文件夹、回收站和搜索结果不可修改
+func (fs *FileSystem) checkWritable(name string) error {
+	if fs.isVirtualPath(name) {
+		return os.ErrPermission
+	}
+
+	m := fs.findMount(name)
+	if m == nil || m.readonly || m.name == name {
+		return os.ErrPermission
+	}
+
+	return nil
+}
+
+// checkMountWritable name 所在的挂载点只读时拒绝修改, 用于回收站、收藏等不在原路径上操作的文件,
+// 与 checkWritable 不同, 允许修改挂载点本身
+func (fs *FileSystem) checkMountWritable(name string) error {
+	m := fs.findMount(name)
+	if fs.readonly || m == nil || m.readonly {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+var _ webdav.File = &virtualDirFile{}
+
+// virtualDirFile 虚拟文件夹, 只能列举
+type virtualDirFile struct {
+	fi       fs.FileInfo
+	children []fs.FileInfo
+}
+
+func (f *virtualDirFile) Read(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (f *virtualDirFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *virtualDirFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (f *virtualDirFile) Close() error {
+	return nil
+}
+
+func (f *virtualDirFile) Readdir(count int) ([]fs.FileInfo, error) {
+	return f.children, nil
+}
+
+func (f *virtualDirFile) Stat() (fs.FileInfo, error) {
+	return f.fi, nil
+}
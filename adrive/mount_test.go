@@ -0,0 +1,236 @@
+package adrive
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/isayme/go-alipanopen"
+)
+
+func TestUniqueFileNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []*alipanopen.File
+		want  map[string]string // 显示的文件名 => 文件 ID
+	}{
+		{
+			name:  "空列表",
+			items: nil,
+			want:  map[string]string{},
+		},
+		{
+			name: "不重名",
+			items: []*alipanopen.File{
+				{FileName: "a.txt", FileId: "1"},
+				{FileName: "b.txt", FileId: "2"},
+			},
+			want: map[string]string{"a.txt": "1", "b.txt": "2"},
+		},
+		{
+			name: "重名时全部加上文件 ID",
+			items: []*alipanopen.File{
+				{FileName: "a.txt", FileId: "1"},
+				{FileName: "a.txt", FileId: "2"},
+				{FileName: "b.txt", FileId: "3"},
+			},
+			want: map[string]string{"a.txt~1": "1", "a.txt~2": "2", "b.txt": "3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := uniqueFileNames(tt.items)
+			if len(got) != len(tt.want) {
+				t.Fatalf("uniqueFileNames() = %v, want %v", fileNames(got), tt.want)
+			}
+			for name, fileId := range tt.want {
+				if file, ok := got[name]; !ok || file.FileId != fileId {
+					t.Errorf("uniqueFileNames()[%q] = %v, want file %s", name, file, fileId)
+				}
+			}
+		})
+	}
+}
+
+func fileNames(files map[string]*alipanopen.File) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestUniqueResolvedFiles(t *testing.T) {
+	files := []*resolvedFile{
+		{name: "/a/x.txt", file: &alipanopen.File{FileName: "x.txt", FileId: "1"}},
+		{name: "/b/x.txt", file: &alipanopen.File{FileName: "x.txt", FileId: "2"}},
+	}
+
+	got := uniqueResolvedFiles(files)
+	if got["x.txt~1"].name != "/a/x.txt" || got["x.txt~2"].name != "/b/x.txt" {
+		t.Errorf("uniqueResolvedFiles() = %v", got)
+	}
+}
+
+func TestNewMounts(t *testing.T) {
+	driveInfo := &alipanopen.GetDriveInfoResp{BackupDriveId: "b", ResourceDriveId: "r"}
+
+	tests := []struct {
+		name    string
+		configs []MountConfig
+		wantErr bool
+	}{
+		{"不同路径", []MountConfig{{Path: "/a"}, {Path: "/b", Drive: DRIVE_RESOURCE}}, false},
+		{"前缀相同但不嵌套", []MountConfig{{Path: "/a"}, {Path: "/ab"}}, false},
+		{"重复", []MountConfig{{Path: "/a"}, {Path: "a/"}}, true},
+		{"嵌套在已有挂载点下", []MountConfig{{Path: "/a"}, {Path: "/a/b"}}, true},
+		{"包含已有挂载点", []MountConfig{{Path: "/a/b"}, {Path: "/a"}}, true},
+		{"根目录包含其他挂载点", []MountConfig{{Path: "/a"}, {Path: "/"}}, true},
+		{"未知网盘", []MountConfig{{Path: "/a", Drive: "other"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newMounts(tt.configs, driveInfo)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newMounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	mounts, err := newMounts([]MountConfig{{Path: "photos", Drive: DRIVE_RESOURCE, RootDir: "相册", Readonly: true}}, driveInfo)
+	if err != nil {
+		t.Fatalf("newMounts() error: %v", err)
+	}
+	if m := mounts[0]; m.name != "/photos" || m.driveId != "r" || m.rootDir != "/相册" || !m.readonly {
+		t.Errorf("newMounts() = %+v", m)
+	}
+
+	if _, err := newMounts([]MountConfig{{Path: "/a", Drive: DRIVE_RESOURCE}}, &alipanopen.GetDriveInfoResp{BackupDriveId: "b"}); err == nil {
+		t.Errorf("newMounts() without resource drive should fail")
+	}
+}
+
+// newTestMounts 与 initMounts 一样按路径长度排序
+func newTestMounts(mounts ...*mount) []*mount {
+	for _, m := range mounts {
+		m.rootFile = NewFileInfo(&alipanopen.File{FileId: m.name, FileName: m.name, Type: alipanopen.FILE_TYPE_FOLDER}, 0)
+	}
+	sort.Slice(mounts, func(i, j int) bool {
+		return len(mounts[i].name) > len(mounts[j].name)
+	})
+	return mounts
+}
+
+func TestFindMount(t *testing.T) {
+	fs := &FileSystem{mounts: newTestMounts(
+		&mount{name: "/"},
+		&mount{name: "/a"},
+		&mount{name: "/x/y"},
+	)}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"/", "/"},
+		{"/a", "/a"},
+		{"/a/b.txt", "/a"},
+		{"/ab", "/"},
+		{"/x", "/"},
+		{"/x/y/z", "/x/y"},
+	}
+
+	for _, tt := range tests {
+		if m := fs.findMount(tt.name); m == nil || m.name != tt.want {
+			t.Errorf("findMount(%q) = %v, want %q", tt.name, m, tt.want)
+		}
+	}
+
+	fs = &FileSystem{mounts: newTestMounts(&mount{name: "/a"})}
+	if m := fs.findMount("/b"); m != nil {
+		t.Errorf("findMount(/b) = %v, want nil", m)
+	}
+}
+
+func TestVirtualChildren(t *testing.T) {
+	fs := &FileSystem{mounts: newTestMounts(
+		&mount{name: "/a"},
+		&mount{name: "/x/y"},
+		&mount{name: "/x/z/w"},
+	)}
+
+	tests := []struct {
+		name   string
+		want   []string
+		wantOk bool
+	}{
+		{"/", []string{"/a", "x"}, true},
+		{"/x", []string{"/x/y", "z"}, true},
+		{"/x/z", []string{"/x/z/w"}, true},
+		{"/a", nil, false},
+		{"/b", nil, false},
+	}
+
+	for _, tt := range tests {
+		children, ok := fs.virtualChildren(tt.name)
+		var got []string
+		for _, child := range children {
+			got = append(got, child.Name())
+		}
+		if ok != tt.wantOk || len(got) != len(tt.want) {
+			t.Errorf("virtualChildren(%q) = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.wantOk)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("virtualChildren(%q) = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	fs := &FileSystem{mounts: newTestMounts(
+		&mount{name: "/rw"},
+		&mount{name: "/ro", readonly: true},
+	)}
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"/rw/a.txt", false},
+		{"/rw/a/b.txt", false},
+		{"/rw", true},
+		{"/ro/a.txt", true},
+		{"/ro", true},
+		{"/", true},
+		{"/other/a.txt", true},
+	}
+
+	for _, tt := range tests {
+		err := fs.checkWritable(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkWritable(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, os.ErrPermission) {
+			t.Errorf("checkWritable(%q) error = %v, want ErrPermission", tt.name, err)
+		}
+	}
+}
+
+func TestMountFileMode(t *testing.T) {
+	fs := &FileSystem{defaultFileMode: 0660}
+
+	if mode := fs.mountFileMode(&mount{name: "/rw"}); mode != 0660 {
+		t.Errorf("mountFileMode(rw) = %o, want 660", mode)
+	}
+	if mode := fs.mountFileMode(&mount{name: "/ro", readonly: true}); mode != 0440 {
+		t.Errorf("mountFileMode(ro) = %o, want 440", mode)
+	}
+}
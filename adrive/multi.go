@@ -0,0 +1,91 @@
+package adrive
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/webdav"
+)
+
+// MultiHandler 多账号时按 URL 前缀 /<账号名>/ 分发请求, 根目录列出所有账号
+type MultiHandler struct {
+	handlers map[string]*Handler
+	root     *webdav.Handler
+}
+
+// NewMultiHandler handlers 的 key 为账号名
+func NewMultiHandler(handlers map[string]*Handler) *MultiHandler {
+	return &MultiHandler{
+		handlers: handlers,
+		root: &webdav.Handler{
+			FileSystem: newAccountsFileSystem(handlers),
+			LockSystem: webdav.NewMemLS(),
+		},
+	}
+}
+
+func (h *MultiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	if handler, ok := h.handlers[name]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	h.root.ServeHTTP(w, r)
+}
+
+var _ webdav.FileSystem = &accountsFileSystem{}
+
+// accountsFileSystem 多账号时的根目录, 只读, 每个账号为一个文件夹
+type accountsFileSystem struct {
+	children []os.FileInfo
+}
+
+func newAccountsFileSystem(handlers map[string]*Handler) *accountsFileSystem {
+	var children []os.FileInfo
+	for name := range handlers {
+		children = append(children, newVirtualDirInfo(name))
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+
+	return &accountsFileSystem{children: children}
+}
+
+func (afs *accountsFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (afs *accountsFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	fi, err := afs.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &virtualDirFile{fi: fi, children: afs.children}, nil
+}
+
+func (afs *accountsFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return os.ErrPermission
+}
+
+func (afs *accountsFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+func (afs *accountsFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if path.Join("/", name) != "/" {
+		return nil, os.ErrNotExist
+	}
+
+	return newVirtualDirInfo("/"), nil
+}
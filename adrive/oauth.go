@@ -0,0 +1,259 @@
+package adrive
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+)
+
+const OPENAPI_HOST = "https://open.aliyundrive.com"
+
+// 授权请求的有效期
+const oauthStateTtl = 10 * time.Minute
+
+// oauthPending 等待回调的授权请求, key 为 state
+type oauthPending struct {
+	mu       sync.Mutex
+	verifier map[string]string
+	expireAt map[string]time.Time
+}
+
+func newOauthPending() *oauthPending {
+	return &oauthPending{
+		verifier: map[string]string{},
+		expireAt: map[string]time.Time{},
+	}
+}
+
+func (pending *oauthPending) put(state, verifier string) {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	now := time.Now()
+	for k, expireAt := range pending.expireAt {
+		if now.After(expireAt) {
+			delete(pending.verifier, k)
+			delete(pending.expireAt, k)
+		}
+	}
+
+	pending.verifier[state] = verifier
+	pending.expireAt[state] = now.Add(oauthStateTtl)
+}
+
+// take 取出 state 对应的 code_verifier, 每个 state 只能使用一次
+func (pending *oauthPending) take(state string) (string, bool) {
+	pending.mu.Lock()
+	defer pending.mu.Unlock()
+
+	verifier, ok := pending.verifier[state]
+	expireAt := pending.expireAt[state]
+	delete(pending.verifier, state)
+	delete(pending.expireAt, state)
+
+	if !ok || time.Now().After(expireAt) {
+		return "", false
+	}
+	return verifier, true
+}
+
+func randomUrlSafeString(n int) (string, error) {
+	bs := make([]byte, n)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// pkceChallenge 计算 S256 方式的 code_challenge
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oauthRedirectUri 回调地址, 未配置时根据请求地址生成
+func (fs *FileSystem) oauthRedirectUri(r *http.Request) string {
+	if fs.oauthRedirect != "" {
+		return fs.oauthRedirect
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	// 经过多层代理时为逗号分隔的多个值, 第一个为客户端使用的协议
+	v := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+	if v == "http" || v == "https" {
+		scheme = v
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, fs.internalPath("oauth/callback"))
+}
+
+// serveOauthAuthorize 跳转到阿里云盘授权页面, 使用 PKCE, 无需 clientSecret
+func (fs *FileSystem) serveOauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	if fs.Ready() {
+		http.Redirect(w, r, fs.homePath(), http.StatusFound)
+		return
+	}
+
+	state, err := randomUrlSafeString(16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	verifier, err := randomUrlSafeString(48)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fs.oauth.put(state, verifier)
+
+	query := url.Values{}
+	query.Set("client_id", fs.tokens.clientId)
+	query.Set("redirect_uri", fs.oauthRedirectUri(r))
+	query.Set("scope", strings.Join(fs.scopes(), ","))
+	query.Set("response_type", "code")
+	query.Set("state", state)
+	query.Set("code_challenge", pkceChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	http.Redirect(w, r, OPENAPI_HOST+"/oauth/authorize?"+query.Encode(), http.StatusFound)
+}
+
+// serveOauthCallback 授权回调, 使用 code 和 code_verifier 换取 token
+func (fs *FileSystem) serveOauthCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if errMsg := query.Get("error"); errMsg != "" {
+		http.Error(w, "授权失败: "+errMsg, http.StatusBadRequest)
+		return
+	}
+
+	verifier, ok := fs.oauth.take(query.Get("state"))
+	if !ok {
+		http.Error(w, "授权请求无效或已过期, 请重新登录", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	token, err := fs.exchangePkceCode(ctx, query.Get("code"), verifier, fs.oauthRedirectUri(r))
+	if err == nil {
+		err = fs.tokens.saveLoginToken(ctx, token)
+	}
+	if err == nil {
+		err = fs.init(ctx)
+	}
+	if err != nil {
+		logger.Errorf("授权登录失败: %v", err)
+		http.Error(w, "授权登录失败: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Infof("授权登录成功, 服务已就绪")
+	http.Redirect(w, r, fs.homePath(), http.StatusFound)
+}
+
+type pkceTokenReq struct {
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	GrantType    string `json:"grant_type"`
+	Code         string `json:"code"`
+	CodeVerifier string `json:"code_verifier"`
+	RedirectUri  string `json:"redirect_uri"`
+}
+
+type pkceTokenResp struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (fs *FileSystem) exchangePkceCode(ctx context.Context, code, verifier, redirectUri string) (*Token, error) {
+	if code == "" {
+		return nil, fmt.Errorf("缺少授权码")
+	}
+
+	reqBody := &pkceTokenReq{
+		ClientId:     fs.tokens.clientId,
+		ClientSecret: fs.tokens.clientSecret,
+		GrantType:    alipanopen.GRANT_TYPE_AUTHORIZATION_CODE,
+		Code:         code,
+		CodeVerifier: verifier,
+		RedirectUri:  redirectUri,
+	}
+	respBody := &pkceTokenResp{}
+	resp, err := restyClient.R().SetContext(ctx).SetBody(reqBody).SetResult(respBody).SetError(respBody).
+		Post(OPENAPI_HOST + "/oauth/access_token")
+	if err != nil {
+		return nil, errors.Wrap(err, "换取 token 失败")
+	}
+	if resp.StatusCode() >= 300 || respBody.AccessToken == "" {
+		return nil, fmt.Errorf("换取 token 失败, 状态码: %d, %s: %s", resp.StatusCode(), respBody.Code, respBody.Message)
+	}
+
+	return &Token{
+		RefreshToken: respBody.RefreshToken,
+		AccessToken:  respBody.AccessToken,
+		ExpireAt:     time.Now().Add(time.Second * time.Duration(respBody.ExpiresIn)),
+	}, nil
+}
+
+func hashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// ImportRefreshTokenOnce 启动时导入 refreshToken. refreshToken 使用一次后即失效,
+// 已有 token 且该 refreshToken 已导入过时忽略, 避免每次启动都使用已失效的 refreshToken.
+func (fs *FileSystem) ImportRefreshTokenOnce(ctx context.Context, refreshToken string) error {
+	refreshToken = strings.TrimSpace(refreshToken)
+
+	token, err := fs.tokens.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if token != nil && token.RefreshToken != "" && token.ImportedHash == hashRefreshToken(refreshToken) {
+		logger.Infof("refreshToken 已导入过, 使用已保存的 token")
+		return nil
+	}
+
+	return fs.ImportRefreshToken(ctx, refreshToken)
+}
+
+// ImportRefreshToken 导入 refreshToken 并立即刷新, 适用于无法扫码的部署环境
+func (fs *FileSystem) ImportRefreshToken(ctx context.Context, refreshToken string) error {
+	refreshToken = strings.TrimSpace(refreshToken)
+	if refreshToken == "" {
+		return fmt.Errorf("refreshToken 不能为空")
+	}
+
+	err := fs.tokens.saveLoginToken(ctx, &Token{RefreshToken: refreshToken, ImportedHash: hashRefreshToken(refreshToken)})
+	if err != nil {
+		return err
+	}
+
+	err = fs.tokens.refresh(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "使用导入的 refreshToken 刷新失败")
+	}
+
+	logger.Infof("导入 refreshToken 成功")
+	return fs.init(ctx)
+}
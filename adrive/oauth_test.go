@@ -0,0 +1,37 @@
+package adrive
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOauthRedirectUri(t *testing.T) {
+	fs := &FileSystem{urlPrefix: "/alice"}
+
+	tests := []struct {
+		proto string
+		want  string
+	}{
+		{"", "http://dav.example.com/alice/-/oauth/callback"},
+		{"https", "https://dav.example.com/alice/-/oauth/callback"},
+		{"HTTPS", "https://dav.example.com/alice/-/oauth/callback"},
+		{"https, http", "https://dav.example.com/alice/-/oauth/callback"},
+		{"http,https", "http://dav.example.com/alice/-/oauth/callback"},
+		{"javascript", "http://dav.example.com/alice/-/oauth/callback"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://dav.example.com/alice/-/oauth/authorize", nil)
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if got := fs.oauthRedirectUri(r); got != tt.want {
+			t.Errorf("oauthRedirectUri() with X-Forwarded-Proto %q = %q, want %q", tt.proto, got, tt.want)
+		}
+	}
+
+	fs.oauthRedirect = "https://other.example.com/cb"
+	if got := fs.oauthRedirectUri(httptest.NewRequest("GET", "/", nil)); got != fs.oauthRedirect {
+		t.Errorf("oauthRedirectUri() = %q, want configured %q", got, fs.oauthRedirect)
+	}
+}
@@ -0,0 +1,41 @@
+package adrive
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/pkg/errors"
+)
+
+// openApiError 开放平台接口错误
+type openApiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// openApiPost 调用 alipanopen 未封装的开放平台接口, 与 call 一样在 accessToken 失效时刷新重试
+func (fs *FileSystem) openApiPost(ctx context.Context, uri string, reqBody, respBody interface{}) error {
+	return fs.call(ctx, func(client *alipanopen.Client) error {
+		token := fs.tokens.currentToken()
+		if token == nil {
+			return fmt.Errorf("未登录")
+		}
+
+		errBody := &openApiError{}
+		resp, err := restyClient.R().SetContext(ctx).
+			SetAuthToken(token.AccessToken).
+			SetBody(reqBody).
+			SetResult(respBody).
+			SetError(errBody).
+			Post(OPENAPI_HOST + uri)
+		if err != nil {
+			return errors.Wrapf(err, "请求 '%s' 失败", uri)
+		}
+		if resp.IsError() {
+			return fmt.Errorf("请求 '%s' 失败, 状态码: %d, %s: %s", uri, resp.StatusCode(), errBody.Code, errBody.Message)
+		}
+
+		return nil
+	})
+}
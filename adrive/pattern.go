@@ -0,0 +1,42 @@
+package adrive
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// checkPattern 检查 glob 规则的语法, 避免写错的规则静默失效
+func checkPattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("规则不能为空")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("规则 '%s' 格式错误: %v", pattern, err)
+	}
+	return nil
+}
+
+// matchPattern 匹配 glob 规则. 规则不含 / 时匹配文件名, 否则匹配完整路径, 以 /** 结尾时匹配该文件夹及其下所有文件.
+func matchPattern(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+
+	if strings.HasSuffix(pattern, "/**") {
+		prefix := strings.TrimSuffix(pattern, "/**")
+		if ok, _ := path.Match(prefix, name); ok {
+			return true
+		}
+		for dir := path.Dir(name); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if ok, _ := path.Match(prefix, dir); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
@@ -0,0 +1,143 @@
+package adrive
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		// 不含 / 时匹配文件名
+		{"*.kdbx", "/docs/secret.kdbx", true},
+		{"*.kdbx", "/docs/secret.txt", false},
+		{"._*", "/a/._photo.jpg", true},
+		{"._*", "/a/photo.jpg", false},
+		{".DS_Store", "/a/b/.DS_Store", true},
+		{"~$*", "/a/~$report.docx", true},
+
+		// 含 / 时匹配完整路径
+		{"/docs/*.txt", "/docs/a.txt", true},
+		{"/docs/*.txt", "/docs/sub/a.txt", false},
+		{"/docs/*.txt", "/other/a.txt", false},
+
+		// 以 /** 结尾时匹配文件夹本身及其下所有文件
+		{"/重要资料/**", "/重要资料", true},
+		{"/重要资料/**", "/重要资料/a.txt", true},
+		{"/重要资料/**", "/重要资料/a/b/c.txt", true},
+		{"/重要资料/**", "/重要资料2/a.txt", false},
+		{"/*/private/**", "/alice/private/a.txt", true},
+		{"/*/private/**", "/alice/public/a.txt", false},
+
+		// 格式错误的规则不匹配任何文件
+		{"[", "/[", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		wantErr bool
+	}{
+		{"*.kdbx", false},
+		{"/重要资料/**", false},
+		{"[a-z]*.txt", false},
+		{"", true},
+		{"[", true},
+		{"/docs/[a-", true},
+		{`*.txt\`, true},
+	}
+
+	for _, tt := range tests {
+		if err := checkPattern(tt.pattern); (err != nil) != tt.wantErr {
+			t.Errorf("checkPattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckDeleteRules(t *testing.T) {
+	tests := []struct {
+		rules   []DeleteRule
+		wantErr bool
+	}{
+		{nil, false},
+		{[]DeleteRule{{Pattern: "*.tmp", Action: DELETE_ACTION_DELETE}, {Pattern: "/a/**"}}, false},
+		{[]DeleteRule{{Pattern: "*.tmp", Action: "remove"}}, true},
+		{[]DeleteRule{{Pattern: "[*.tmp", Action: DELETE_ACTION_DENY}}, true},
+	}
+
+	for i, tt := range tests {
+		if err := checkDeleteRules(tt.rules); (err != nil) != tt.wantErr {
+			t.Errorf("case %d: checkDeleteRules() error = %v, wantErr %v", i, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDeleteAction(t *testing.T) {
+	fs := &FileSystem{deleteRules: []DeleteRule{
+		{Pattern: "*.kdbx", Action: DELETE_ACTION_DENY},
+		{Pattern: "~$*", Action: DELETE_ACTION_DELETE},
+		{Pattern: "/重要资料/**", Action: DELETE_ACTION_DENY},
+		{Pattern: "*.bak"},
+	}}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"/docs/secret.kdbx", DELETE_ACTION_DENY},
+		{"/docs/~$a.docx", DELETE_ACTION_DELETE},
+		{"/重要资料/a.txt", DELETE_ACTION_DENY},
+		{"/docs/a.bak", DELETE_ACTION_TRASH},
+		{"/docs/a.txt", DELETE_ACTION_TRASH},
+	}
+
+	for _, tt := range tests {
+		if got := fs.deleteAction(tt.name); got != tt.want {
+			t.Errorf("deleteAction(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+}
+
+func TestCanDenyUnder(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		// 不含 / 的规则可能匹配任意文件夹下的文件
+		{"*.kdbx", "/", true},
+		{"*.kdbx", "/a/b", true},
+
+		{"/重要资料/**", "/", true},
+		{"/重要资料/**", "/重要资料", true},
+		{"/重要资料/**", "/重要资料/a/b", true},
+		{"/重要资料/**", "/其他", false},
+		{"/*/private/**", "/alice", true},
+		{"/*/private/**", "/alice/public", false},
+
+		{"/docs/*.txt", "/", true},
+		{"/docs/*.txt", "/docs", true},
+		{"/docs/*.txt", "/docs/sub", false},
+		{"/docs/*.txt", "/other", false},
+	}
+
+	for _, tt := range tests {
+		fs := &FileSystem{deleteRules: []DeleteRule{{Pattern: tt.pattern, Action: DELETE_ACTION_DENY}}}
+		if got := fs.canDenyUnder(tt.name); got != tt.want {
+			t.Errorf("canDenyUnder(%q) with %q = %v, want %v", tt.name, tt.pattern, got, tt.want)
+		}
+	}
+
+	fs := &FileSystem{deleteRules: []DeleteRule{{Pattern: "*.kdbx", Action: DELETE_ACTION_DELETE}}}
+	if fs.canDenyUnder("/") {
+		t.Errorf("canDenyUnder() without deny rules = true, want false")
+	}
+}
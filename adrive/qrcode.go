@@ -7,32 +7,50 @@ import (
 	"time"
 
 	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
 	"github.com/isayme/go-ora"
 	"github.com/mdp/qrterminal/v3"
 )
 
-func (fs *FileSystem) authIfRequired(ctx context.Context) error {
-	if fs.accessToken != "" {
-		return nil
-	}
+// 扫码状态文案
+var qrCodeStatusTexts = map[string]string{
+	alipanopen.QRCODE_STATUS_WAITLOGIN:     "等待扫码...",
+	alipanopen.QRCODE_STATUS_SCANSUCCESS:   "已扫码成功",
+	alipanopen.QRCODE_STATUS_LOGINSUCCESS:  "已登录成功",
+	alipanopen.QRCODE_STATUS_QRCODEEXPIRED: "二维码过期",
+}
 
-	scopes := []string{alipanopen.SCOPE_USER_BASE, alipanopen.SCOPE_FILE_ALL_READ}
-	if !fs.readonly {
-		scopes = append(scopes, alipanopen.SCOPE_FILE_ALL_WRITE)
+// LoginWithTerminal 在终端打印二维码扫码登录, 已有 token 时不再扫码
+func (fs *FileSystem) LoginWithTerminal(ctx context.Context) error {
+	if fs.tokens.hasToken() {
+		return fs.init(ctx)
 	}
 
-	reqBody := &alipanopen.GetQrCodeReq{
-		ClientId:     fs.clientId,
-		ClientSecret: fs.clientSecret,
-		Scopes:       scopes,
-	}
-	qrCodeResp, err := fs.client.GetQrCode(ctx, reqBody)
+	ora := ora.New()
+	defer ora.Stop()
+
+	err := fs.qrCodeLogin(ctx, func(qrCodeUrl string) {
+		printQrCode(qrCodeUrl)
+		ora.Start()
+	}, func(status string) {
+		switch status {
+		case alipanopen.QRCODE_STATUS_LOGINSUCCESS:
+			ora.Succeed(qrCodeStatusTexts[status])
+		case alipanopen.QRCODE_STATUS_QRCODEEXPIRED:
+			ora.Fail(qrCodeStatusTexts[status])
+		default:
+			ora.Text(qrCodeStatusTexts[status])
+		}
+	})
 	if err != nil {
 		return err
 	}
 
-	qrCodeText := "https://www.aliyundrive.com/o/oauth/authorize?sid=" + qrCodeResp.Sid
-	qrterminal.GenerateWithConfig(qrCodeText, qrterminal.Config{
+	return fs.init(ctx)
+}
+
+func printQrCode(qrCodeUrl string) {
+	qrterminal.GenerateWithConfig(qrCodeUrl, qrterminal.Config{
 		Level:          qrterminal.L,
 		Writer:         os.Stdout,
 		HalfBlocks:     true,
@@ -42,51 +60,90 @@ func (fs *FileSystem) authIfRequired(ctx context.Context) error {
 		BlackWhiteChar: qrterminal.BLACK_WHITE,
 		QuietZone:      2,
 	})
+}
 
-	ora := ora.New()
-	defer ora.Stop()
-	ora.Start()
-	ora.Text("等待扫码...")
+// qrCodeLogin 扫码登录并保存 token. 获取到二维码时调用 onQrCode, 每次查询扫码状态后调用 onStatus.
+// 二维码过期后自动重新生成, 直到达到最大次数或超时; ctx 取消时立即返回.
+func (fs *FileSystem) qrCodeLogin(ctx context.Context, onQrCode func(qrCodeUrl string), onStatus func(status string)) error {
+	if fs.loginTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fs.loginTimeout)
+		defer cancel()
+	}
 
-	done := false
+	for attempt := 1; ; attempt++ {
+		err := fs.qrCodeLoginOnce(ctx, onQrCode, onStatus)
+		if err != errQrCodeExpired {
+			if err == context.DeadlineExceeded {
+				return fmt.Errorf("等待扫码超时")
+			}
+			return err
+		}
 
-	for {
-		if done {
-			break
+		if fs.qrCodeMaxAttempts > 0 && attempt >= fs.qrCodeMaxAttempts {
+			return fmt.Errorf("二维码过期, 已达到最大生成次数 %d", fs.qrCodeMaxAttempts)
 		}
+		logger.Infof("二维码过期, 重新生成二维码")
+	}
+}
+
+var errQrCodeExpired = fmt.Errorf("二维码过期")
+
+// scopes 登录时申请的权限, 只读模式不申请写权限
+func (fs *FileSystem) scopes() []string {
+	scopes := []string{alipanopen.SCOPE_USER_BASE, alipanopen.SCOPE_FILE_ALL_READ}
+	if !fs.readonly {
+		scopes = append(scopes, alipanopen.SCOPE_FILE_ALL_WRITE)
+	}
+	return scopes
+}
 
-		qrCodeStatusResp, err := fs.client.GetQrCodeStatus(ctx, qrCodeResp.Sid)
+func (fs *FileSystem) qrCodeLoginOnce(ctx context.Context, onQrCode func(qrCodeUrl string), onStatus func(status string)) error {
+	reqBody := &alipanopen.GetQrCodeReq{
+		ClientId:     fs.tokens.clientId,
+		ClientSecret: fs.tokens.clientSecret,
+		Scopes:       fs.scopes(),
+	}
+	qrCodeResp, err := fs.tokens.authClient.GetQrCode(ctx, reqBody)
+	if err != nil {
+		return err
+	}
+
+	qrCodeUrl := "https://www.aliyundrive.com/o/oauth/authorize?sid=" + qrCodeResp.Sid
+	onQrCode(qrCodeUrl)
+
+	for {
+		qrCodeStatusResp, err := fs.tokens.authClient.GetQrCodeStatus(ctx, qrCodeResp.Sid)
 		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			return err
 		}
 
+		onStatus(qrCodeStatusResp.Status)
+
 		switch qrCodeStatusResp.Status {
-		case alipanopen.QRCODE_STATUS_WAITLOGIN:
-			ora.Text("等待扫码...")
-			time.Sleep(time.Second)
-		case alipanopen.QRCODE_STATUS_SCANSUCCESS:
-			ora.Text("已扫码成功")
-			time.Sleep(time.Second)
 		case alipanopen.QRCODE_STATUS_LOGINSUCCESS:
-			ora.Succeed("已登录成功")
-
 			reqBody := &alipanopen.RefreshTokenReq{
-				ClientId:     fs.clientId,
-				ClientSecret: fs.clientSecret,
+				ClientId:     fs.tokens.clientId,
+				ClientSecret: fs.tokens.clientSecret,
 				GrantType:    alipanopen.GRANT_TYPE_AUTHORIZATION_CODE,
 				Code:         qrCodeStatusResp.AuthCode,
 			}
-			refreshTokenResp, err := fs.client.RefreshToken(ctx, reqBody)
+			refreshTokenResp, err := fs.tokens.authClient.RefreshToken(ctx, reqBody)
 			if err != nil {
 				return err
 			}
-			fs.saveToken(refreshTokenResp)
-			done = true
+			return fs.tokens.saveLoginToken(ctx, tokenFromResp(refreshTokenResp))
 		case alipanopen.QRCODE_STATUS_QRCODEEXPIRED:
-			ora.Fail("二维码过期")
-			return fmt.Errorf("二维码过期")
+			return errQrCodeExpired
+		default:
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
 		}
 	}
-
-	return nil
 }
@@ -0,0 +1,120 @@
+package adrive
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"path"
+	"strconv"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+)
+
+type rapidUploadReq struct {
+	DriveId         string `json:"drive_id"`
+	ParentFileId    string `json:"parent_file_id"`
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	CheckNameMode   string `json:"check_name_mode"`
+	Size            int64  `json:"size"`
+	ContentHash     string `json:"content_hash"`
+	ContentHashName string `json:"content_hash_name"`
+	ProofCode       string `json:"proof_code"`
+	ProofVersion    string `json:"proof_version"`
+}
+
+type rapidUploadResp struct {
+	FileId      string `json:"file_id"`
+	UploadId    string `json:"upload_id"`
+	RapidUpload bool   `json:"rapid_upload"`
+}
+
+// proofCode 秒传校验码: 取 accessToken md5 的前 16 位作为偏移量, 读取文件该位置的 8 个字节
+func proofCode(accessToken string, r io.ReaderAt, size int64) (string, error) {
+	if size == 0 {
+		return "", nil
+	}
+
+	sum := md5.Sum([]byte(accessToken))
+	v, err := strconv.ParseUint(hex.EncodeToString(sum[:])[:16], 16, 64)
+	if err != nil {
+		return "", err
+	}
+
+	start := int64(v % uint64(size))
+	end := start + 8
+	if end > size {
+		end = size
+	}
+
+	buf := make([]byte, end-start)
+	_, err = r.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// RapidUpload 尝试秒传文件, contentHash 为文件 SHA1.
+// 网盘中已有相同内容时直接创建文件并返回 true; 否则返回 false, 需要正常上传.
+func (fs *FileSystem) RapidUpload(ctx context.Context, name string, r io.ReaderAt, size int64, contentHash string) (bool, error) {
+	name = fs.resolve(name)
+
+	if err := fs.checkWritable(name); err != nil {
+		return false, err
+	}
+
+	parentFolder, err := fs.getFile(ctx, path.Dir(name))
+	if err != nil {
+		return false, err
+	}
+
+	token := fs.tokens.currentToken()
+	if token == nil {
+		return false, nil
+	}
+	code, err := proofCode(token.AccessToken, r, size)
+	if err != nil {
+		return false, err
+	}
+
+	reqBody := &rapidUploadReq{
+		DriveId:         parentFolder.DriveId,
+		ParentFileId:    parentFolder.FileId,
+		Name:            path.Base(name),
+		Type:            alipanopen.FILE_TYPE_FILE,
+		CheckNameMode:   alipanopen.CHECK_NAME_MODE_REFUSE,
+		Size:            size,
+		ContentHash:     contentHash,
+		ContentHashName: "sha1",
+		ProofCode:       code,
+		ProofVersion:    "v1",
+	}
+	respBody := &rapidUploadResp{}
+	err = fs.openApiPost(ctx, "/adrive/v1.0/openFile/create", reqBody, respBody)
+	if err != nil {
+		return false, err
+	}
+
+	if respBody.RapidUpload {
+		logger.Infof("秒传文件 '%s' 成功", name)
+		return true, nil
+	}
+
+	// 未命中秒传, 删除已创建的待上传文件
+	err = fs.call(ctx, func(client *alipanopen.Client) error {
+		return client.DeleteFile(ctx, &alipanopen.DeleteFileReq{
+			DriveId: parentFolder.DriveId,
+			FileId:  respBody.FileId,
+		})
+	})
+	if err != nil {
+		logger.Warnf("删除未完成的文件 '%s' 失败: %v", name, err)
+	}
+
+	return false, nil
+}
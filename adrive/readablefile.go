@@ -15,8 +15,9 @@ import (
 var _ webdav.File = &ReadableFile{}
 
 type ReadableFile struct {
-	fi *FileInfo
-	fs *FileSystem
+	fi   *FileInfo
+	fs   *FileSystem
+	name string // 完整路径, 用于列举保存在本地的系统文件
 
 	pos  int64
 	rc   io.ReadCloser
@@ -143,6 +144,19 @@ func (readableFile *ReadableFile) Readdir(count int) (result []fs.FileInfo, err
 	for idx, file := range files {
 		result[idx] = file
 	}
+	result = append(result, readableFile.fs.hlsPlaylistInfos(files)...)
+
+	if readableFile.name != "" {
+		names := map[string]bool{}
+		for _, fi := range result {
+			names[fi.Name()] = true
+		}
+		for _, fi := range readableFile.fs.localJunkInfos(readableFile.name) {
+			if !names[fi.Name()] {
+				result = append(result, fi)
+			}
+		}
+	}
 
 	return result, nil
 }
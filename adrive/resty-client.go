@@ -11,6 +11,9 @@ import (
 
 var restyClient *resty.Client
 
+// noRetryRestyClient 不重试, 用于非幂等的请求, 如 token 的 CompareAndSwap
+var noRetryRestyClient = resty.New()
+
 func init() {
 	c := resty.New()
 	c.SetRetryCount(3)
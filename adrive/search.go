@@ -0,0 +1,401 @@
+package adrive
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	searchUri  = "/adrive/v1.0/openFile/search"
+	getPathUri = "/adrive/v1.0/openFile/get_path"
+)
+
+// 每次搜索最多返回的文件数
+const maxSearchResults = 100
+
+// 搜索结果缓存时间
+const searchCacheDuration = 30 * time.Second
+
+var searchCategories = []string{"video", "doc", "audio", "zip", "image", "others"}
+
+type searchReq struct {
+	DriveId string `json:"drive_id"`
+	Query   string `json:"query"`
+	Limit   int    `json:"limit"`
+	OrderBy string `json:"order_by"`
+}
+
+type searchResp struct {
+	Items      []*alipanopen.File `json:"items"`
+	NextMarker string             `json:"next_marker"`
+}
+
+type getPathReq struct {
+	DriveId string `json:"drive_id"`
+	FileId  string `json:"file_id"`
+}
+
+type getPathResp struct {
+	Items []*alipanopen.File `json:"items"`
+}
+
+// searchQuery 搜索条件, 为空的条件不限制
+type searchQuery struct {
+	name     string
+	category string
+	fileType string
+	ext      string
+	after    time.Time
+	before   time.Time
+}
+
+// parseSearchQuery 解析搜索文件夹名, 如 "报告 category:doc after:2023-01-01".
+// 支持 category, type, ext, after, before, 其余内容作为文件名关键字.
+func parseSearchQuery(s string) (*searchQuery, error) {
+	q := &searchQuery{}
+
+	var keywords []string
+	for _, field := range strings.Fields(s) {
+		key, value := "", field
+		if i := strings.Index(field, ":"); i > 0 {
+			key, value = field[:i], field[i+1:]
+		}
+
+		var err error
+		switch key {
+		case "":
+			keywords = append(keywords, value)
+		case "category":
+			if !containsString(searchCategories, value) {
+				return nil, fmt.Errorf("未知的文件分类: %s, 可选: %s", value, strings.Join(searchCategories, ", "))
+			}
+			q.category = value
+		case "type":
+			if value != alipanopen.FILE_TYPE_FILE && value != alipanopen.FILE_TYPE_FOLDER {
+				return nil, fmt.Errorf("未知的文件类型: %s, 可选: file, folder", value)
+			}
+			q.fileType = value
+		case "ext":
+			q.ext = strings.TrimPrefix(value, ".")
+		case "after":
+			q.after, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		case "before":
+			q.before, err = time.ParseInLocation("2006-01-02", value, time.Local)
+		default:
+			keywords = append(keywords, field)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("日期格式错误: %s, 应为 2006-01-02", value)
+		}
+	}
+	q.name = strings.Join(keywords, " ")
+
+	if *q == (searchQuery{}) {
+		return nil, fmt.Errorf("搜索条件不能为空")
+	}
+	return q, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// openApiQuery 转为开放平台搜索接口的 query 参数
+func (q *searchQuery) openApiQuery() string {
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+
+	var conditions []string
+	if q.name != "" {
+		conditions = append(conditions, "name match "+quote(q.name))
+	}
+	if q.category != "" {
+		conditions = append(conditions, "category = "+quote(q.category))
+	}
+	if q.fileType != "" {
+		conditions = append(conditions, "type = "+quote(q.fileType))
+	}
+	if q.ext != "" {
+		conditions = append(conditions, "file_extension = "+quote(q.ext))
+	}
+	if !q.after.IsZero() {
+		conditions = append(conditions, "updated_at >= "+quote(q.after.UTC().Format("2006-01-02T15:04:05")))
+	}
+	if !q.before.IsZero() {
+		conditions = append(conditions, "updated_at < "+quote(q.before.UTC().Format("2006-01-02T15:04:05")))
+	}
+	return strings.Join(conditions, " and ")
+}
+
+// resolvedFile 接口返回的文件, name 为其在 webdav 中的路径
+type resolvedFile struct {
+	name string
+	file *alipanopen.File
+}
+
+// search 在已挂载的网盘中搜索, 只返回挂载点下的文件, 按修改时间倒序
+func (fs *FileSystem) search(ctx context.Context, q *searchQuery) ([]*resolvedFile, error) {
+	query := q.openApiQuery()
+	result, err := fs.cached("search:"+query, searchCacheDuration, func() (interface{}, error) {
+		var items []*alipanopen.File
+		for _, driveId := range fs.mountedDriveIds() {
+			respBody := &searchResp{}
+			reqBody := &searchReq{DriveId: driveId, Query: query, Limit: maxSearchResults, OrderBy: "updated_at DESC"}
+			err := fs.openApiPost(ctx, searchUri, reqBody, respBody)
+			if err != nil {
+				return nil, errors.Wrap(err, "搜索失败")
+			}
+			items = append(items, respBody.Items...)
+		}
+
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].UpdatedAt.After(items[j].UpdatedAt)
+		})
+
+		return fs.resolveFiles(ctx, items, maxSearchResults)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]*resolvedFile), nil
+}
+
+// cached 读取缓存, 缓存不存在时调用 fn 并缓存结果, 同一 key 同时只调用一次
+func (fs *FileSystem) cached(key string, duration time.Duration, fn func() (interface{}, error)) (interface{}, error) {
+	if v, ok := fs.cache.Get(key); ok {
+		return v, nil
+	}
+
+	result, err, _ := fs.sg.Do(key, func() (interface{}, error) {
+		result, err := fn()
+		if err != nil {
+			return nil, err
+		}
+
+		fs.cache.Set(key, result, duration)
+		return result, nil
+	})
+	return result, err
+}
+
+// resolveFiles 获取文件在 webdav 中的路径, 忽略不在挂载点下的文件, 最多返回 limit 个
+func (fs *FileSystem) resolveFiles(ctx context.Context, items []*alipanopen.File, limit int) ([]*resolvedFile, error) {
+	// 同一文件夹下的文件共用已获取的上级文件夹, 避免每个文件都调用一次接口
+	ancestors := map[string]*alipanopen.File{}
+
+	files := []*resolvedFile{}
+	for _, item := range items {
+		if len(files) >= limit {
+			break
+		}
+
+		name, err := fs.resolveFilePath(ctx, item, ancestors)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			files = append(files, &resolvedFile{name: name, file: item})
+		}
+	}
+	return files, nil
+}
+
+// filePath 根据文件 ID 获取其在 webdav 中的路径, 不在任何挂载点下时返回空字符串
+func (fs *FileSystem) filePath(ctx context.Context, file *alipanopen.File) (string, error) {
+	return fs.resolveFilePath(ctx, file, map[string]*alipanopen.File{})
+}
+
+func ancestorKey(driveId, fileId string) string {
+	return driveId + ":" + fileId
+}
+
+// resolveFilePath 同 filePath, ancestors 为已获取的上级文件夹, key 为 ancestorKey, 上级文件夹不全时才调用接口获取
+func (fs *FileSystem) resolveFilePath(ctx context.Context, file *alipanopen.File, ancestors map[string]*alipanopen.File) (string, error) {
+	fetched := false
+
+	for _, m := range fs.mounts {
+		if m.driveId != file.DriveId || m.rootFile.FileId == file.FileId {
+			continue
+		}
+
+		for {
+			names := []string{file.FileName}
+			parentFileId := file.ParentFileId
+			for parentFileId != m.rootFile.FileId {
+				parent, ok := ancestors[ancestorKey(file.DriveId, parentFileId)]
+				if !ok {
+					break
+				}
+				names = append([]string{parent.FileName}, names...)
+				parentFileId = parent.ParentFileId
+			}
+
+			if parentFileId == m.rootFile.FileId {
+				return path.Join(append([]string{m.name}, names...)...), nil
+			}
+			// 已追溯到网盘根目录, 文件不在此挂载点下
+			if fetched || parentFileId == alipanopen.ROOT_FOLDER_ID {
+				break
+			}
+
+			respBody := &getPathResp{}
+			err := fs.openApiPost(ctx, getPathUri, &getPathReq{DriveId: file.DriveId, FileId: file.FileId}, respBody)
+			if err != nil {
+				return "", errors.Wrapf(err, "获取文件 '%s' 的路径失败", file.FileName)
+			}
+			for _, item := range respBody.Items {
+				ancestors[ancestorKey(file.DriveId, item.FileId)] = item
+			}
+			fetched = true
+		}
+	}
+
+	return "", nil
+}
+
+// isSearchPath name 是否为搜索虚拟文件夹或其中的文件
+func (fs *FileSystem) isSearchPath(name string) bool {
+	return fs.searchDir != "" && (name == fs.searchDir || strings.HasPrefix(name, fs.searchDir+"/"))
+}
+
+// splitSearchPath 将搜索文件夹下的路径拆分为搜索条件、搜索结果的文件名和其下的相对路径
+func (fs *FileSystem) splitSearchPath(name string) (query, fileName, rel string) {
+	parts := strings.SplitN(strings.TrimPrefix(name, fs.searchDir+"/"), "/", 3)
+	query = parts[0]
+	if len(parts) > 1 {
+		fileName = parts[1]
+	}
+	if len(parts) > 2 {
+		rel = parts[2]
+	}
+	return query, fileName, rel
+}
+
+// uniqueResolvedFiles key 为虚拟文件夹中显示的文件名
+func uniqueResolvedFiles(files []*resolvedFile) map[string]*resolvedFile {
+	items := make([]*alipanopen.File, len(files))
+	byFileId := map[string]*resolvedFile{}
+	for i, file := range files {
+		items[i] = file.file
+		byFileId[file.file.FileId] = file
+	}
+
+	result := map[string]*resolvedFile{}
+	for name, item := range uniqueFileNames(items) {
+		result[name] = byFileId[item.FileId]
+	}
+	return result
+}
+
+// statResolvedFile 获取虚拟文件夹中的文件 fileName, rel 不为空时获取其下的文件
+func (fs *FileSystem) statResolvedFile(ctx context.Context, files map[string]*resolvedFile, fileName, rel string) (*FileInfo, error) {
+	file, ok := files[fileName]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	if rel != "" {
+		return fs.getFile(ctx, path.Join(file.name, rel))
+	}
+
+	f := *file.file
+	f.FileName = fileName
+	return NewFileInfo(&f, 0440), nil
+}
+
+// resolvedDirFile 列举虚拟文件夹
+func resolvedDirFile(name string, files map[string]*resolvedFile) *virtualDirFile {
+	children := make([]os.FileInfo, 0, len(files))
+	for childName, file := range files {
+		f := *file.file
+		f.FileName = childName
+		children = append(children, NewFileInfo(&f, 0440))
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+
+	return &virtualDirFile{fi: newVirtualDirInfo(name), children: children}
+}
+
+// searchFiles 搜索文件夹 /<searchDir>/<query> 下的文件, key 为显示的文件名
+func (fs *FileSystem) searchFiles(ctx context.Context, query string) (map[string]*resolvedFile, error) {
+	q, err := parseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := fs.search(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return uniqueResolvedFiles(results), nil
+}
+
+// statSearch 搜索结果中的文件显示为搜索文件夹的子文件, 搜索结果中的文件夹可继续展开
+func (fs *FileSystem) statSearch(ctx context.Context, name string) (*FileInfo, error) {
+	if name == fs.searchDir {
+		return newVirtualDirInfo(path.Base(name)), nil
+	}
+
+	query, fileName, rel := fs.splitSearchPath(name)
+	if _, err := parseSearchQuery(query); err != nil {
+		// 客户端会查看任意文件夹名, 搜索条件无效时按文件不存在处理, 以免中断整个 PROPFIND
+		logger.Infof("搜索条件 '%s' 无效: %v", query, err)
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	if fileName == "" {
+		return newVirtualDirInfo(query), nil
+	}
+
+	files, err := fs.searchFiles(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.statResolvedFile(ctx, files, fileName, rel)
+}
+
+// openSearchFile 搜索结果只读
+func (fs *FileSystem) openSearchFile(ctx context.Context, name string, flag int) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	query, fileName, _ := fs.splitSearchPath(name)
+	if name != fs.searchDir && fileName == "" {
+		files, err := fs.searchFiles(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		return resolvedDirFile(query, files), nil
+	}
+
+	fi, err := fs.statSearch(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if name == fs.searchDir {
+		return &virtualDirFile{fi: fi}, nil
+	}
+	return NewReadableFile(fi, fs), nil
+}
@@ -0,0 +1,102 @@
+package adrive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	day := func(s string) time.Time {
+		v, _ := time.ParseInLocation("2006-01-02", s, time.Local)
+		return v
+	}
+
+	tests := []struct {
+		query   string
+		want    searchQuery
+		wantErr bool
+	}{
+		{query: "报告", want: searchQuery{name: "报告"}},
+		{query: "年度 报告 category:doc", want: searchQuery{name: "年度 报告", category: "doc"}},
+		{query: "type:folder", want: searchQuery{fileType: "folder"}},
+		{query: "ext:.mp4", want: searchQuery{ext: "mp4"}},
+		{query: "after:2023-01-01 before:2023-02-01", want: searchQuery{after: day("2023-01-01"), before: day("2023-02-01")}},
+		{query: "a:b", want: searchQuery{name: "a:b"}},
+		{query: ":x", want: searchQuery{name: ":x"}},
+
+		{query: "", wantErr: true},
+		{query: "   ", wantErr: true},
+		{query: "category:movie", wantErr: true},
+		{query: "type:link", wantErr: true},
+		{query: "after:2023/01/01", wantErr: true},
+		{query: "before:yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSearchQuery(tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSearchQuery(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err == nil && *got != tt.want {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.query, *got, tt.want)
+		}
+	}
+}
+
+func TestOpenApiQuery(t *testing.T) {
+	after := time.Date(2023, 1, 1, 8, 0, 0, 0, time.FixedZone("CST", 8*3600))
+
+	tests := []struct {
+		query searchQuery
+		want  string
+	}{
+		{searchQuery{name: "报告"}, `name match "报告"`},
+		{searchQuery{name: `a"b`}, `name match "a\"b"`},
+		{searchQuery{category: "doc", fileType: "file"}, `category = "doc" and type = "file"`},
+		{searchQuery{ext: "mp4", after: after}, `file_extension = "mp4" and updated_at >= "2023-01-01T00:00:00"`},
+		{searchQuery{before: after}, `updated_at < "2023-01-01T00:00:00"`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.query.openApiQuery(); got != tt.want {
+			t.Errorf("openApiQuery(%+v) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestResolveFilePathWithAncestors(t *testing.T) {
+	fs := &FileSystem{mounts: []*mount{
+		{name: "/docs", driveId: "d1", rootFile: NewFileInfo(&alipanopen.File{FileId: "docs"}, 0)},
+		{name: "/", driveId: "d1", rootFile: NewFileInfo(&alipanopen.File{FileId: "root"}, 0)},
+	}}
+
+	// 上级文件夹已获取时不调用接口
+	ancestors := map[string]*alipanopen.File{
+		ancestorKey("d1", "a"):    {FileId: "a", FileName: "a", ParentFileId: "docs"},
+		ancestorKey("d1", "docs"): {FileId: "docs", FileName: "docs", ParentFileId: "root"},
+	}
+
+	tests := []struct {
+		file *alipanopen.File
+		want string
+	}{
+		{&alipanopen.File{DriveId: "d1", FileId: "1", FileName: "x.txt", ParentFileId: "a"}, "/docs/a/x.txt"},
+		{&alipanopen.File{DriveId: "d1", FileId: "2", FileName: "y.txt", ParentFileId: "docs"}, "/docs/y.txt"},
+		{&alipanopen.File{DriveId: "d1", FileId: "3", FileName: "z.txt", ParentFileId: "root"}, "/z.txt"},
+		{&alipanopen.File{DriveId: "d2", FileId: "4", FileName: "w.txt", ParentFileId: "root"}, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := fs.resolveFilePath(context.Background(), tt.file, ancestors)
+		if err != nil {
+			t.Fatalf("resolveFilePath(%s) error: %v", tt.file.FileName, err)
+		}
+		if got != tt.want {
+			t.Errorf("resolveFilePath(%s) = %q, want %q", tt.file.FileName, got, tt.want)
+		}
+	}
+}
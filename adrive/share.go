@@ -0,0 +1,334 @@
+package adrive
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+)
+
+const (
+	shareCreateUri = "/adrive/v1.0/share_link/create"
+	shareListUri   = "/adrive/v1.0/share_link/list"
+	shareCancelUri = "/adrive/v1.0/share_link/cancel"
+)
+
+// 随机生成提取码
+const SHARE_CODE_RANDOM = "random"
+
+var shareCodeRegexp = regexp.MustCompile(`^[a-zA-Z0-9]{4}$`)
+
+// ShareLink 分享链接
+type ShareLink struct {
+	ShareId    string    `json:"share_id"`
+	ShareUrl   string    `json:"share_url"`
+	SharePwd   string    `json:"share_pwd"`
+	ShareName  string    `json:"share_name"`
+	DriveId    string    `json:"drive_id"`
+	FileIdList []string  `json:"file_id_list"`
+	Expiration string    `json:"expiration"` // 为空时永久有效
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	Path       string    `json:"path,omitempty"` // 分享的文件在 webdav 中的路径
+}
+
+type shareCreateReq struct {
+	DriveId    string   `json:"drive_id"`
+	FileIdList []string `json:"file_id_list"`
+	Expiration string   `json:"expiration,omitempty"`
+	SharePwd   string   `json:"share_pwd,omitempty"`
+}
+
+type shareListReq struct {
+	Limit  int    `json:"limit"`
+	Marker string `json:"marker,omitempty"`
+}
+
+type shareListResp struct {
+	Items      []*ShareLink `json:"items"`
+	NextMarker string       `json:"next_marker"`
+}
+
+type shareCancelReq struct {
+	ShareId string `json:"share_id"`
+}
+
+// errInvalidShareArg 参数错误, 接口返回 400
+type errInvalidShareArg struct {
+	msg string
+}
+
+func (e *errInvalidShareArg) Error() string {
+	return e.msg
+}
+
+// randomShareCode 生成 4 位提取码
+func randomShareCode() (string, error) {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	bs := make([]byte, 4)
+	for i := range bs {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+		if err != nil {
+			return "", err
+		}
+		bs[i] = letters[n.Int64()]
+	}
+	return string(bs), nil
+}
+
+// parseShareCode 检查提取码, 为 random 时随机生成
+func parseShareCode(code string) (string, error) {
+	if code == SHARE_CODE_RANDOM {
+		return randomShareCode()
+	}
+	if code != "" && !shareCodeRegexp.MatchString(code) {
+		return "", &errInvalidShareArg{"提取码应为 4 位字母或数字"}
+	}
+	return code, nil
+}
+
+// ParseShareExpire 解析有效期, 支持 Go 的时间格式(如 24h)和天数(如 7d), 为空时永久有效
+func ParseShareExpire(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+
+	return 0, &errInvalidShareArg{fmt.Sprintf("有效期格式错误: %s, 应为 24h 或 7d 等", s)}
+}
+
+// CreateShare 分享文件或文件夹, expire 为 0 时永久有效, code 为空时不设置提取码, 为 random 时随机生成
+func (fs *FileSystem) CreateShare(ctx context.Context, name string, expire time.Duration, code string) (*ShareLink, error) {
+	name = fs.resolve(name)
+	if m := fs.findMount(name); m == nil || m.name == name || fs.isVirtualPath(name) {
+		return nil, &errInvalidShareArg{fmt.Sprintf("不能分享 '%s'", name)}
+	}
+	if err := fs.checkMountWritable(name); err != nil {
+		return nil, err
+	}
+
+	file, err := fs.getFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err = parseShareCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody := &shareCreateReq{
+		DriveId:    file.DriveId,
+		FileIdList: []string{file.FileId},
+		SharePwd:   code,
+	}
+	if expire > 0 {
+		reqBody.Expiration = time.Now().Add(expire).UTC().Format(time.RFC3339)
+	}
+
+	share := &ShareLink{}
+	err = fs.openApiPost(ctx, shareCreateUri, reqBody, share)
+	if err != nil {
+		return nil, errors.Wrap(err, "创建分享失败")
+	}
+
+	logger.Infof("分享 '%s' 成功, 链接: %s", name, share.ShareUrl)
+	share.Path = name
+	return share, nil
+}
+
+// ListShares 列举文件在挂载点下的分享
+func (fs *FileSystem) ListShares(ctx context.Context) ([]*ShareLink, error) {
+	driveIds := fs.mountedDriveIds()
+	// 同一文件夹下的分享共用已获取的上级文件夹
+	ancestors := map[string]*alipanopen.File{}
+
+	shares := []*ShareLink{}
+	marker := ""
+	for {
+		respBody := &shareListResp{}
+		err := fs.openApiPost(ctx, shareListUri, &shareListReq{Limit: 100, Marker: marker}, respBody)
+		if err != nil {
+			return nil, errors.Wrap(err, "列举分享失败")
+		}
+
+		for _, share := range respBody.Items {
+			if !containsString(driveIds, share.DriveId) {
+				continue
+			}
+
+			share.Path, err = fs.sharePath(ctx, share, ancestors)
+			if err != nil {
+				return nil, err
+			}
+			if share.Path != "" {
+				shares = append(shares, share)
+			}
+		}
+		if respBody.NextMarker == "" {
+			return shares, nil
+		}
+		marker = respBody.NextMarker
+	}
+}
+
+// sharePath 分享的文件在 webdav 中的路径, 分享多个文件时返回第一个文件的路径, 有文件不在挂载点下或已删除时返回空字符串
+func (fs *FileSystem) sharePath(ctx context.Context, share *ShareLink, ancestors map[string]*alipanopen.File) (string, error) {
+	result := ""
+	for _, fileId := range share.FileIdList {
+		file := &alipanopen.File{}
+		err := fs.openApiPost(ctx, fileGetUri, &fileGetReq{DriveId: share.DriveId, FileId: fileId}, file)
+		if err != nil {
+			logger.Warnf("获取分享 '%s' 的文件失败: %v", share.ShareId, err)
+			return "", nil
+		}
+
+		name, err := fs.resolveFilePath(ctx, file, ancestors)
+		if err != nil || name == "" {
+			return "", err
+		}
+		if result == "" {
+			result = name
+		}
+	}
+	return result, nil
+}
+
+// findShare 按 ID 查找分享, 不获取分享的文件路径
+func (fs *FileSystem) findShare(ctx context.Context, shareId string) (*ShareLink, error) {
+	marker := ""
+	for {
+		respBody := &shareListResp{}
+		err := fs.openApiPost(ctx, shareListUri, &shareListReq{Limit: 100, Marker: marker}, respBody)
+		if err != nil {
+			return nil, errors.Wrap(err, "列举分享失败")
+		}
+
+		for _, share := range respBody.Items {
+			if share.ShareId == shareId {
+				return share, nil
+			}
+		}
+		if respBody.NextMarker == "" {
+			return nil, os.ErrNotExist
+		}
+		marker = respBody.NextMarker
+	}
+}
+
+// CancelShare 取消分享, 只能取消挂载点下文件的分享
+func (fs *FileSystem) CancelShare(ctx context.Context, shareId string) error {
+	if shareId == "" {
+		return &errInvalidShareArg{"缺少分享 ID"}
+	}
+
+	share, err := fs.findShare(ctx, shareId)
+	if err != nil {
+		return err
+	}
+	if !containsString(fs.mountedDriveIds(), share.DriveId) {
+		return os.ErrNotExist
+	}
+
+	share.Path, err = fs.sharePath(ctx, share, map[string]*alipanopen.File{})
+	if err != nil {
+		return err
+	}
+	if share.Path == "" {
+		return os.ErrNotExist
+	}
+	if err := fs.checkMountWritable(share.Path); err != nil {
+		return err
+	}
+
+	err = fs.openApiPost(ctx, shareCancelUri, &shareCancelReq{ShareId: shareId}, &struct{}{})
+	if err != nil {
+		return errors.Wrap(err, "取消分享失败")
+	}
+
+	logger.Infof("取消分享 '%s' 成功, 文件: %s", shareId, share.Path)
+	return nil
+}
+
+// serveShare 分享接口: GET 列举分享, POST 创建分享, DELETE 取消分享
+func (fs *FileSystem) serveShare(w http.ResponseWriter, r *http.Request) {
+	if !fs.Ready() {
+		http.Error(w, "未登录, 请访问 "+fs.internalPath("login")+" 扫码登录", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		shares, err := fs.ListShares(ctx)
+		if err != nil {
+			writeShareError(w, err)
+			return
+		}
+		writeJson(w, http.StatusOK, shares)
+	case http.MethodPost:
+		expire, err := ParseShareExpire(r.FormValue("expire"))
+		if err != nil {
+			writeShareError(w, err)
+			return
+		}
+
+		share, err := fs.CreateShare(ctx, r.FormValue("path"), expire, r.FormValue("code"))
+		if err != nil {
+			logger.Errorf("分享 '%s' 失败: %v", r.FormValue("path"), err)
+			writeShareError(w, err)
+			return
+		}
+		writeJson(w, http.StatusCreated, share)
+	case http.MethodDelete:
+		err := fs.CancelShare(ctx, r.FormValue("id"))
+		if err != nil {
+			writeShareError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "不支持的请求方法", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeShareError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if _, ok := err.(*errInvalidShareArg); ok {
+		status = http.StatusBadRequest
+	} else if err == os.ErrNotExist {
+		status = http.StatusNotFound
+	} else if err == os.ErrPermission {
+		status = http.StatusForbidden
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write([]byte(util.Stringify(v)))
+}
@@ -0,0 +1,81 @@
+package adrive
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseShareExpire(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{s: "", want: 0},
+		{s: "24h", want: 24 * time.Hour},
+		{s: "90m", want: 90 * time.Minute},
+		{s: "7d", want: 7 * 24 * time.Hour},
+
+		{s: "0d", wantErr: true},
+		{s: "-1d", wantErr: true},
+		{s: "-1h", wantErr: true},
+		{s: "0s", wantErr: true},
+		{s: "1.5d", wantErr: true},
+		{s: "d", wantErr: true},
+		{s: "7", wantErr: true},
+		{s: "一周", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseShareExpire(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseShareExpire(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if _, ok := err.(*errInvalidShareArg); !ok {
+				t.Errorf("ParseShareExpire(%q) error type = %T, want *errInvalidShareArg", tt.s, err)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseShareExpire(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseShareCode(t *testing.T) {
+	tests := []struct {
+		code    string
+		wantErr bool
+	}{
+		{code: ""},
+		{code: "ab12"},
+		{code: "AB12"},
+		{code: "abc", wantErr: true},
+		{code: "abcde", wantErr: true},
+		{code: "ab-1", wantErr: true},
+		{code: "提取码四", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseShareCode(tt.code)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseShareCode(%q) error = %v, wantErr %v", tt.code, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.code {
+			t.Errorf("parseShareCode(%q) = %q, want unchanged", tt.code, got)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		code, err := parseShareCode(SHARE_CODE_RANDOM)
+		if err != nil {
+			t.Fatalf("parseShareCode(random) error: %v", err)
+		}
+		if !shareCodeRegexp.MatchString(code) {
+			t.Errorf("parseShareCode(random) = %q, not a valid code", code)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package adrive
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	starredListUri = "/adrive/v1.0/openFile/starredList"
+	fileUpdateUri  = "/adrive/v1.0/openFile/update"
+)
+
+// 收藏列表缓存时间
+const starredCacheDuration = time.Minute
+const starredCacheKey = "starred"
+
+// 获取收藏列表失败后暂停查询的时间
+const starredFailCacheDuration = 10 * time.Second
+const starredFailCacheKey = "starred:fail"
+
+// 收藏属性, PROPPATCH 设置为 true 时收藏, 删除或设置为 false 时取消收藏
+var starredPropName = xml.Name{Space: "https://github.com/isayme/aliyundrive-webdav", Local: "starred"}
+
+type starredListReq struct {
+	DriveId string `json:"drive_id"`
+	Limit   int    `json:"limit"`
+	Marker  string `json:"marker,omitempty"`
+}
+
+type starredListResp struct {
+	Items      []*alipanopen.File `json:"items"`
+	NextMarker string             `json:"next_marker"`
+}
+
+type fileUpdateReq struct {
+	DriveId string `json:"drive_id"`
+	FileId  string `json:"file_id"`
+	Starred bool   `json:"starred"`
+}
+
+// listFolder 由接口返回的文件列表组成的只读虚拟文件夹, 如最近文件和收藏
+type listFolder struct {
+	name string
+	list func(ctx context.Context) ([]*resolvedFile, error)
+}
+
+// findListFolder 返回 name 所在的虚拟文件夹, 不在任何虚拟文件夹下时返回 nil
+func (fs *FileSystem) findListFolder(name string) *listFolder {
+	for _, folder := range fs.listFolders {
+		if name == folder.name || strings.HasPrefix(name, folder.name+"/") {
+			return folder
+		}
+	}
+	return nil
+}
+
+func (fs *FileSystem) newListFolders(recentDir, starredDir string) []*listFolder {
+	var folders []*listFolder
+	if recentDir != "" {
+		folders = append(folders, &listFolder{name: recentDir, list: fs.listRecent})
+	}
+	if starredDir != "" {
+		folders = append(folders, &listFolder{name: starredDir, list: fs.listStarredFolder})
+	}
+	return folders
+}
+
+// listRecent 最近修改或上传的文件
+func (fs *FileSystem) listRecent(ctx context.Context) ([]*resolvedFile, error) {
+	return fs.search(ctx, &searchQuery{fileType: alipanopen.FILE_TYPE_FILE})
+}
+
+// listStarred 已挂载网盘中收藏的文件
+func (fs *FileSystem) listStarred(ctx context.Context) ([]*alipanopen.File, error) {
+	result, err := fs.cached(starredCacheKey, starredCacheDuration, func() (interface{}, error) {
+		var items []*alipanopen.File
+		for _, driveId := range fs.mountedDriveIds() {
+			marker := ""
+			for {
+				respBody := &starredListResp{}
+				err := fs.openApiPost(ctx, starredListUri, &starredListReq{DriveId: driveId, Limit: 100, Marker: marker}, respBody)
+				if err != nil {
+					return nil, errors.Wrap(err, "列举收藏失败")
+				}
+
+				items = append(items, respBody.Items...)
+				if respBody.NextMarker == "" {
+					break
+				}
+				marker = respBody.NextMarker
+			}
+		}
+
+		sort.SliceStable(items, func(i, j int) bool {
+			return items[i].UpdatedAt.After(items[j].UpdatedAt)
+		})
+		return items, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]*alipanopen.File), nil
+}
+
+func (fs *FileSystem) listStarredFolder(ctx context.Context) ([]*resolvedFile, error) {
+	items, err := fs.listStarred(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := fs.cached(starredCacheKey+":folder", starredCacheDuration, func() (interface{}, error) {
+		return fs.resolveFiles(ctx, items, len(items))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]*resolvedFile), nil
+}
+
+// splitListFolderPath 将虚拟文件夹下的路径拆分为文件名和其下的相对路径
+func splitListFolderPath(folder *listFolder, name string) (fileName, rel string) {
+	if name == folder.name {
+		return "", ""
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(name, folder.name+"/"), "/", 2)
+	fileName = parts[0]
+	if len(parts) > 1 {
+		rel = parts[1]
+	}
+	return fileName, rel
+}
+
+func (fs *FileSystem) statListFolder(ctx context.Context, folder *listFolder, name string) (*FileInfo, error) {
+	fileName, rel := splitListFolderPath(folder, name)
+	if fileName == "" {
+		return newVirtualDirInfo(path.Base(name)), nil
+	}
+
+	files, err := folder.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.statResolvedFile(ctx, uniqueResolvedFiles(files), fileName, rel)
+}
+
+// openListFolderFile 虚拟文件夹只读, O_RDWR 用于 PROPPATCH 修改收藏
+func (fs *FileSystem) openListFolderFile(ctx context.Context, folder *listFolder, name string, flag int) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	if name == folder.name {
+		files, err := folder.list(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return resolvedDirFile(path.Base(name), uniqueResolvedFiles(files)), nil
+	}
+
+	fi, err := fs.statListFolder(ctx, folder, name)
+	if err != nil {
+		return nil, err
+	}
+	return NewReadableFile(fi, fs), nil
+}
+
+// starredFileIds 收藏的文件 ID, key 为网盘 ID
+func (fs *FileSystem) starredFileIds(ctx context.Context) (map[string]map[string]bool, error) {
+	result, err := fs.cached(starredCacheKey+":ids", starredCacheDuration, func() (interface{}, error) {
+		items, err := fs.listStarred(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		ids := map[string]map[string]bool{}
+		for _, item := range items {
+			if ids[item.DriveId] == nil {
+				ids[item.DriveId] = map[string]bool{}
+			}
+			ids[item.DriveId][item.FileId] = true
+		}
+		return ids, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(map[string]map[string]bool), nil
+}
+
+// isStarred 文件是否已收藏, 获取收藏列表失败时视为未收藏
+func (fs *FileSystem) isStarred(ctx context.Context, fi *FileInfo) bool {
+	if _, ok := fs.cache.Get(starredFailCacheKey); ok {
+		return false
+	}
+
+	ids, err := fs.starredFileIds(ctx)
+	if err != nil {
+		// 列举文件夹时每个文件都会查询, 失败后暂时不再请求
+		logger.Warnf("获取收藏列表失败: %v", err)
+		fs.cache.Set(starredFailCacheKey, true, starredFailCacheDuration)
+		return false
+	}
+
+	return ids[fi.DriveId][fi.FileId]
+}
+
+// setStarred 收藏或取消收藏, name 为文件在 webdav 中的路径, 所在挂载点只读时拒绝
+func (fs *FileSystem) setStarred(ctx context.Context, name string, fi *FileInfo, starred bool) error {
+	if err := fs.checkMountWritable(name); err != nil {
+		return err
+	}
+
+	err := fs.openApiPost(ctx, fileUpdateUri, &fileUpdateReq{DriveId: fi.DriveId, FileId: fi.FileId, Starred: starred}, &struct{}{})
+	if err != nil {
+		return err
+	}
+
+	fs.cache.Delete(starredCacheKey)
+	fs.cache.Delete(starredCacheKey + ":ids")
+	fs.cache.Delete(starredCacheKey + ":folder")
+	if starred {
+		logger.Infof("收藏 '%s' 成功", name)
+	} else {
+		logger.Infof("取消收藏 '%s' 成功", name)
+	}
+	return nil
+}
+
+var _ webdav.DeadPropsHolder = &ReadableFile{}
+
+// DeadProps 返回收藏属性, 只有已收藏的文件有该属性
+func (readableFile *ReadableFile) DeadProps() (map[xml.Name]webdav.Property, error) {
+	props := map[xml.Name]webdav.Property{}
+	if readableFile.fi.IsVirtual() || !readableFile.fs.isStarred(context.Background(), readableFile.fi) {
+		return props, nil
+	}
+
+	props[starredPropName] = webdav.Property{
+		XMLName:  starredPropName,
+		InnerXML: []byte("true"),
+	}
+	return props, nil
+}
+
+// Patch 只支持修改收藏属性
+func (readableFile *ReadableFile) Patch(patches []webdav.Proppatch) ([]webdav.Propstat, error) {
+	var props, unsupported []webdav.Property
+	starred := false
+	for _, patch := range patches {
+		for _, prop := range patch.Props {
+			if prop.XMLName != starredPropName {
+				unsupported = append(unsupported, webdav.Property{XMLName: prop.XMLName})
+				continue
+			}
+
+			value := strings.TrimSpace(string(prop.InnerXML))
+			starred = !patch.Remove && value != "false" && value != "0"
+			props = append(props, webdav.Property{XMLName: prop.XMLName})
+		}
+	}
+
+	// 有不支持的属性时全部不修改
+	if len(unsupported) > 0 {
+		stats := []webdav.Propstat{{Props: unsupported, Status: http.StatusForbidden}}
+		if len(props) > 0 {
+			stats = append(stats, webdav.Propstat{Props: props, Status: http.StatusFailedDependency})
+		}
+		return stats, nil
+	}
+
+	if len(props) > 0 {
+		err := readableFile.setStarred(starred)
+		if err == os.ErrPermission {
+			return []webdav.Propstat{{Props: props, Status: http.StatusForbidden}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []webdav.Propstat{{Props: props, Status: http.StatusOK}}, nil
+}
+
+// setStarred 虚拟文件夹中的文件没有路径, 通过文件 ID 获取所在的挂载点
+func (readableFile *ReadableFile) setStarred(starred bool) error {
+	ctx := context.Background()
+
+	name := readableFile.name
+	if name == "" {
+		var err error
+		name, err = readableFile.fs.filePath(ctx, readableFile.fi.File)
+		if err != nil {
+			return err
+		}
+	}
+
+	return readableFile.fs.setStarred(ctx, name, readableFile.fi, starred)
+}
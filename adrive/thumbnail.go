@@ -0,0 +1,355 @@
+package adrive
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+const fileGetUri = "/adrive/v1.0/openFile/get"
+
+// 缩略图的扩展名, 缩略图均为 jpeg
+const thumbnailExt = ".jpg"
+
+// 缩略图缓存时间
+const thumbnailCacheDuration = 10 * time.Minute
+
+// 缩略图缓存的总大小上限, 超过时淘汰最久未使用的缩略图
+const maxThumbnailCacheBytes = 64 * 1024 * 1024
+
+type fileGetReq struct {
+	DriveId string `json:"drive_id"`
+	FileId  string `json:"file_id"`
+}
+
+func hasThumbnail(fi *FileInfo) bool {
+	return !fi.IsDir() && (fi.Category == "image" || fi.Category == "video")
+}
+
+// parseThumbnailPath 解析缩略图文件夹下的路径, 如 /a/.thumbs/b.jpg 为 /a/b 的缩略图.
+// fileName 为空时 name 为缩略图文件夹本身. 只按名称判断, 与 thumbnailDir 同名的网盘文件夹会被缩略图文件夹遮住,
+// 无法访问或修改, 配置时应选择不会用到的名称.
+func (fs *FileSystem) parseThumbnailPath(name string) (dir, fileName string, ok bool) {
+	if fs.thumbnailDir == "" {
+		return "", "", false
+	}
+
+	if path.Base(name) == fs.thumbnailDir {
+		return path.Dir(name), "", true
+	}
+	if path.Base(path.Dir(name)) == fs.thumbnailDir {
+		return path.Dir(path.Dir(name)), path.Base(name), true
+	}
+	return "", "", false
+}
+
+func (fs *FileSystem) isThumbnailPath(name string) bool {
+	_, _, ok := fs.parseThumbnailPath(name)
+	return ok
+}
+
+func newThumbnailInfo(fi *FileInfo, size int64) *FileInfo {
+	return NewFileInfo(&alipanopen.File{
+		FileName:  fi.FileName + thumbnailExt,
+		FileSize:  size,
+		Type:      alipanopen.FILE_TYPE_FILE,
+		CreatedAt: fi.CreatedAt,
+		UpdatedAt: fi.UpdatedAt,
+	}, 0440)
+}
+
+func thumbnailNotExist(name string) error {
+	return &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+func thumbnailCacheKey(fi *FileInfo) string {
+	return fmt.Sprintf("%s:%s", fi.DriveId, fi.FileId)
+}
+
+// cachedThumbnail 已下载的缩略图, 不调用接口
+func (fs *FileSystem) cachedThumbnail(fi *FileInfo) ([]byte, bool) {
+	return fs.thumbnails.get(thumbnailCacheKey(fi))
+}
+
+// prefetchThumbnails 在后台下载同一文件夹中的缩略图, 同一文件夹同时只下载一次
+func (fs *FileSystem) prefetchThumbnails(files []*FileInfo) {
+	fs.sg.Do(fmt.Sprintf("thumbnailPrefetch:%s:%s", files[0].DriveId, files[0].ParentFileId), func() (interface{}, error) {
+		for _, file := range files {
+			if _, err := fs.thumbnail(context.Background(), file); err != nil {
+				logger.Warnf("下载 '%s' 的缩略图失败: %v", file.Name(), err)
+			}
+		}
+		return nil, nil
+	})
+}
+
+// thumbnail 下载文件的缩略图, 缩略图链接过期时重新获取文件信息
+func (fs *FileSystem) thumbnail(ctx context.Context, fi *FileInfo) ([]byte, error) {
+	if !hasThumbnail(fi) {
+		return nil, thumbnailNotExist(fi.Name() + thumbnailExt)
+	}
+
+	if data, ok := fs.cachedThumbnail(fi); ok {
+		return data, nil
+	}
+
+	key := thumbnailCacheKey(fi)
+	result, err, _ := fs.sg.Do("thumbnail:"+key, func() (interface{}, error) {
+		data, err := fs.fetchFileThumbnail(ctx, fi)
+		if err != nil {
+			return nil, err
+		}
+
+		fs.thumbnails.set(key, data, thumbnailCacheDuration)
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]byte), nil
+}
+
+func (fs *FileSystem) fetchFileThumbnail(ctx context.Context, fi *FileInfo) ([]byte, error) {
+	if fi.Thumbnail != "" {
+		data, err := fetchThumbnail(ctx, fi.Thumbnail)
+		if err == nil {
+			return data, nil
+		}
+		logger.Debugf("下载 '%s' 的缩略图失败, 重新获取缩略图链接: %v", fi.Name(), err)
+	}
+
+	file := &alipanopen.File{}
+	err := fs.openApiPost(ctx, fileGetUri, &fileGetReq{DriveId: fi.DriveId, FileId: fi.FileId}, file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "获取 '%s' 的缩略图链接失败", fi.Name())
+	}
+	if file.Thumbnail == "" {
+		return nil, thumbnailNotExist(fi.Name() + thumbnailExt)
+	}
+
+	return fetchThumbnail(ctx, file.Thumbnail)
+}
+
+func fetchThumbnail(ctx context.Context, thumbnailUrl string) ([]byte, error) {
+	resp, err := restyClient.R().SetContext(ctx).SetHeader("Referer", ALIYUNDRIVE_HOST+"/").Get(thumbnailUrl)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() >= 300 {
+		return nil, fmt.Errorf("状态码: %d", resp.StatusCode())
+	}
+	return resp.Body(), nil
+}
+
+// statThumbnail 缩略图文件夹或其中的缩略图, 下载并缓存缩略图以获取实际大小, 下载失败时返回 *os.PathError
+func (fs *FileSystem) statThumbnail(ctx context.Context, name string) (*FileInfo, error) {
+	dir, fileName, _ := fs.parseThumbnailPath(name)
+
+	if fileName == "" {
+		fi, err := fs.getFile(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, thumbnailNotExist(name)
+		}
+		return newVirtualDirInfo(fs.thumbnailDir), nil
+	}
+
+	fi, err := fs.getThumbnailSource(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := fs.thumbnail(ctx, fi)
+	if err != nil {
+		if _, ok := err.(*os.PathError); !ok {
+			err = &os.PathError{Op: "stat", Path: name, Err: err}
+		}
+		return nil, err
+	}
+	return newThumbnailInfo(fi, int64(len(data))), nil
+}
+
+// getThumbnailSource 获取缩略图对应的图片或视频
+func (fs *FileSystem) getThumbnailSource(ctx context.Context, name string) (*FileInfo, error) {
+	dir, fileName, _ := fs.parseThumbnailPath(name)
+	if !strings.HasSuffix(fileName, thumbnailExt) {
+		return nil, thumbnailNotExist(name)
+	}
+
+	fi, err := fs.getFile(ctx, path.Join(dir, strings.TrimSuffix(fileName, thumbnailExt)))
+	if err == os.ErrNotExist || (err == nil && !hasThumbnail(fi)) {
+		return nil, thumbnailNotExist(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+// openThumbnail 缩略图文件夹只读, 列举时只包含已下载的缩略图, 大小与查看文件信息时一致, 其余的在后台下载
+func (fs *FileSystem) openThumbnail(ctx context.Context, name string, flag int) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	dir, fileName, _ := fs.parseThumbnailPath(name)
+
+	if fileName == "" {
+		fi, err := fs.getFile(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return nil, thumbnailNotExist(name)
+		}
+
+		files, err := fs.listDir(ctx, fi)
+		if err != nil {
+			return nil, err
+		}
+
+		var children []os.FileInfo
+		var pending []*FileInfo
+		for _, file := range files {
+			if !hasThumbnail(file) {
+				continue
+			}
+			if data, ok := fs.cachedThumbnail(file); ok {
+				children = append(children, newThumbnailInfo(file, int64(len(data))))
+			} else {
+				pending = append(pending, file)
+			}
+		}
+		if len(pending) > 0 {
+			go fs.prefetchThumbnails(pending)
+		}
+		return &virtualDirFile{fi: newVirtualDirInfo(fs.thumbnailDir), children: children}, nil
+	}
+
+	fi, err := fs.getThumbnailSource(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := fs.thumbnail(ctx, fi)
+	if err != nil {
+		return nil, err
+	}
+	return newMemFile(fileName, data, fi.ModTime()), nil
+}
+
+// serveThumbnail 处理带 thumbnail 参数的 GET 请求, 返回文件的缩略图
+func (fs *FileSystem) serveThumbnail(w http.ResponseWriter, r *http.Request) {
+	name := fs.resolve(strings.TrimPrefix(r.URL.Path, fs.urlPrefix))
+
+	fi, err := fs.Stat(r.Context(), name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	file, ok := fi.(*FileInfo)
+	if !ok || !hasThumbnail(file) {
+		http.Error(w, "该文件没有缩略图", http.StatusNotFound)
+		return
+	}
+
+	data, err := fs.thumbnail(r.Context(), file)
+	if os.IsNotExist(err) {
+		http.Error(w, "该文件没有缩略图", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logger.Warnf("获取 '%s' 的缩略图失败: %v", name, err)
+		http.Error(w, "获取缩略图失败", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "private, max-age=600")
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(data))
+}
+
+// thumbnailCache 缩略图缓存, 按总大小淘汰最久未使用的缩略图
+type thumbnailCache struct {
+	lock     sync.Mutex
+	maxBytes int
+	bytes    int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type thumbnailCacheEntry struct {
+	key      string
+	data     []byte
+	expireAt time.Time
+}
+
+func newThumbnailCache(maxBytes int) *thumbnailCache {
+	return &thumbnailCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (c *thumbnailCache) get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*thumbnailCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.remove(e)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(e)
+	return entry.data, true
+}
+
+// set 超过总大小上限的缩略图不缓存
+func (c *thumbnailCache) set(key string, data []byte, duration time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	if len(data) > c.maxBytes {
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&thumbnailCacheEntry{key: key, data: data, expireAt: time.Now().Add(duration)})
+	c.bytes += len(data)
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *thumbnailCache) remove(e *list.Element) {
+	entry := e.Value.(*thumbnailCacheEntry)
+	c.ll.Remove(e)
+	delete(c.items, entry.key)
+	c.bytes -= len(entry.data)
+}
@@ -0,0 +1,82 @@
+package adrive
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dghubble/trie"
+	"github.com/isayme/go-alipanopen"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/sync/singleflight"
+)
+
+func TestThumbnailCache(t *testing.T) {
+	c := newThumbnailCache(10)
+
+	c.set("a", []byte("aaaa"), time.Minute)
+	c.set("b", []byte("bbbb"), time.Minute)
+	c.get("a")
+	// 超过总大小时淘汰最久未使用的 b
+	c.set("c", []byte("cccc"), time.Minute)
+
+	steps := []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+	for _, step := range steps {
+		if _, ok := c.get(step.key); ok != step.want {
+			t.Errorf("get(%q) ok = %v, want %v", step.key, ok, step.want)
+		}
+	}
+	if c.bytes != 8 {
+		t.Errorf("bytes = %d, want 8", c.bytes)
+	}
+
+	// 覆盖时重新计算大小, 超过上限的不缓存
+	c.set("a", []byte("aa"), time.Minute)
+	c.set("d", make([]byte, 11), time.Minute)
+	if _, ok := c.get("d"); ok || c.bytes != 6 {
+		t.Errorf("get(\"d\") ok = %v, bytes = %d, want false, 6", ok, c.bytes)
+	}
+
+	c.set("e", []byte("e"), -time.Second)
+	if _, ok := c.get("e"); ok || c.bytes != 6 {
+		t.Errorf("expired get(\"e\") ok = %v, bytes = %d, want false, 6", ok, c.bytes)
+	}
+}
+
+func TestStatThumbnailUsesRealSize(t *testing.T) {
+	fs := &FileSystem{
+		thumbnailDir: ".thumbs",
+		thumbnails:   newThumbnailCache(maxThumbnailCacheBytes),
+		cache:        cache.New(time.Minute, time.Minute),
+		root:         trie.NewPathTrie(),
+		sg:           &singleflight.Group{},
+		mounts: []*mount{
+			{name: "/", driveId: "d", rootFile: NewFileInfo(&alipanopen.File{DriveId: "d", FileId: "root", Type: alipanopen.FILE_TYPE_FOLDER}, 0660)},
+		},
+	}
+
+	photo := NewFileInfo(&alipanopen.File{DriveId: "d", FileId: "p", FileName: "a.heic", Category: "image", Type: alipanopen.FILE_TYPE_FILE}, 0660)
+	fs.root.Put("/a.heic", photo)
+	fs.thumbnails.set(thumbnailCacheKey(photo), []byte("jpeg data"), time.Minute)
+
+	fi, err := fs.statThumbnail(context.Background(), "/.thumbs/a.heic.jpg")
+	if err != nil {
+		t.Fatalf("statThumbnail() error: %v", err)
+	}
+	if fi.Name() != "a.heic.jpg" || fi.Size() != 9 {
+		t.Errorf("statThumbnail() = %s, %d, want a.heic.jpg, 9", fi.Name(), fi.Size())
+	}
+
+	// 不同网盘中文件 ID 相同的文件不共用缩略图
+	other := NewFileInfo(&alipanopen.File{DriveId: "d2", FileId: "p"}, 0)
+	if _, ok := fs.cachedThumbnail(other); ok {
+		t.Errorf("cachedThumbnail() shares thumbnails across drives")
+	}
+}
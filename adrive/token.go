@@ -0,0 +1,395 @@
+package adrive
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+)
+
+// 刷新 token 的租约时长, 也是等待其他实例刷新的最长时间
+const tokenLeaseTtl = time.Minute
+
+// accessToken 剩余有效期小于该值时视为需要刷新
+const tokenExpireMargin = 5 * time.Minute
+
+// 刷新失败后的重试间隔
+const minRefreshBackoff = 30 * time.Second
+const maxRefreshBackoff = 30 * time.Minute
+
+// 连续刷新失败达到该次数时告警
+const refreshAlertThreshold = 3
+
+func (token *Token) valid() bool {
+	return token.AccessToken != "" && time.Now().Add(tokenExpireMargin).Before(token.ExpireAt)
+}
+
+// genInstanceId 生成实例标识, 用于区分租约持有者
+func genInstanceId() string {
+	hostname, _ := os.Hostname()
+
+	bs := make([]byte, 4)
+	rand.Read(bs)
+
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(bs))
+}
+
+// isAccessTokenError 判断是否为 accessToken 无效或过期导致的错误
+func isAccessTokenError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "AccessTokenInvalid") || strings.Contains(msg, "AccessTokenExpired")
+}
+
+func newAlipanClient(accessToken string) *alipanopen.Client {
+	client := alipanopen.NewClient()
+	client.SetRestyClient(restyClient)
+	if accessToken != "" {
+		client.SetAccessToken(accessToken)
+	}
+	return client
+}
+
+// tokenManager 管理 token 的读取、刷新和保存, 可被多个协程并发使用.
+// 每次 token 变化时创建新的 client, 避免修改正在使用的 client.
+type tokenManager struct {
+	clientId     string
+	clientSecret string
+	store        TokenStore
+	instanceId   string
+	alertWebhook string
+
+	// 未设置 accessToken, 用于登录和刷新 token
+	authClient *alipanopen.Client
+
+	mu     sync.RWMutex
+	token  *Token
+	client *alipanopen.Client
+
+	// 保证同一时间只有一个协程刷新
+	refreshMu sync.Mutex
+	failures  int
+}
+
+func newTokenManager(clientId, clientSecret string, store TokenStore, alertWebhook string) *tokenManager {
+	return &tokenManager{
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		store:        store,
+		instanceId:   genInstanceId(),
+		alertWebhook: alertWebhook,
+		authClient:   newAlipanClient(""),
+	}
+}
+
+func (tm *tokenManager) hasToken() bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	return tm.token != nil
+}
+
+func (tm *tokenManager) currentToken() *Token {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	return tm.token
+}
+
+func (tm *tokenManager) useToken(token *Token) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.token = token
+	tm.client = newAlipanClient(token.AccessToken)
+}
+
+// load 读取已保存的 token, accessToken 即将过期时刷新
+func (tm *tokenManager) load(ctx context.Context) error {
+	token, err := tm.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	if token == nil || token.RefreshToken == "" {
+		return nil
+	}
+
+	if token.valid() {
+		tm.useToken(token)
+		return nil
+	}
+
+	err = tm.refresh(ctx, nil)
+	if err != nil {
+		logger.Warnf("使用 refreshToken 刷新 token 失败: %v", err)
+	}
+	return nil
+}
+
+// getClient 返回已设置 accessToken 的 client, accessToken 即将过期时先刷新
+func (tm *tokenManager) getClient(ctx context.Context) (*alipanopen.Client, error) {
+	tm.mu.RLock()
+	token, client := tm.token, tm.client
+	tm.mu.RUnlock()
+
+	if token == nil {
+		return nil, fmt.Errorf("未登录")
+	}
+
+	if token.valid() {
+		return client, nil
+	}
+
+	err := tm.refresh(ctx, client)
+	if err != nil {
+		// 刷新失败时仍尝试使用旧 accessToken
+		logger.Warnf("刷新 token 失败: %v", err)
+		return client, nil
+	}
+
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	return tm.client, nil
+}
+
+// refresh 刷新 token. failedClient 不为空时, 若当前 client 已不是 failedClient, 说明其他协程已刷新过, 直接返回.
+func (tm *tokenManager) refresh(ctx context.Context, failedClient *alipanopen.Client) error {
+	tm.refreshMu.Lock()
+	defer tm.refreshMu.Unlock()
+
+	tm.mu.RLock()
+	token, client := tm.token, tm.client
+	tm.mu.RUnlock()
+
+	if failedClient != nil && client != failedClient && token != nil && token.valid() {
+		return nil
+	}
+
+	return tm.refreshWithLease(ctx)
+}
+
+// refreshWithLease 先获取租约, 获取失败说明其他实例正在刷新, 等待其保存新 token 后直接使用.
+func (tm *tokenManager) refreshWithLease(ctx context.Context) error {
+	acquired, err := tm.store.AcquireLease(ctx, tm.instanceId, tokenLeaseTtl)
+	if err != nil {
+		return errors.Wrap(err, "获取刷新租约失败")
+	}
+	if !acquired {
+		logger.Infof("其他实例正在刷新 token, 等待刷新结果")
+		return tm.waitTokenRefreshed(ctx)
+	}
+	defer func() {
+		err := tm.store.ReleaseLease(context.Background(), tm.instanceId)
+		if err != nil {
+			logger.Warnf("释放刷新租约失败: %v", err)
+		}
+	}()
+
+	token, err := tm.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if token == nil || token.RefreshToken == "" {
+		return fmt.Errorf("refreshToken 不存在, 请重新登录")
+	}
+
+	// 其他实例已刷新过
+	if tm.isNewerToken(token) {
+		logger.Infof("使用其他实例刷新的 token")
+		tm.useToken(token)
+		return nil
+	}
+
+	reqBody := &alipanopen.RefreshTokenReq{
+		ClientId:     tm.clientId,
+		ClientSecret: tm.clientSecret,
+		RefreshToken: token.RefreshToken,
+		GrantType:    alipanopen.GRANT_TYPE_REFRESH_TOKEN,
+	}
+	refreshTokenResp, err := tm.authClient.RefreshToken(ctx, reqBody)
+	if err != nil {
+		return err
+	}
+
+	newToken := tokenFromResp(refreshTokenResp)
+	newToken.ImportedHash = token.ImportedHash
+	return tm.storeToken(ctx, token.Version, newToken)
+}
+
+func (tm *tokenManager) isNewerToken(token *Token) bool {
+	if !token.valid() {
+		return false
+	}
+
+	current := tm.currentToken()
+	return current == nil || token.Version > current.Version
+}
+
+func (tm *tokenManager) waitTokenRefreshed(ctx context.Context) error {
+	deadline := time.Now().Add(tokenLeaseTtl)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+
+		token, err := tm.store.Load(ctx)
+		if err != nil {
+			return err
+		}
+
+		if token != nil && tm.isNewerToken(token) {
+			tm.useToken(token)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("等待其他实例刷新 token 超时")
+}
+
+func tokenFromResp(refreshTokenResp *alipanopen.RefreshTokenResp) *Token {
+	return &Token{
+		RefreshToken: refreshTokenResp.RefreshToken,
+		AccessToken:  refreshTokenResp.AccessToken,
+		ExpireAt:     time.Now().Add(time.Second * time.Duration(refreshTokenResp.ExpiresIn)),
+	}
+}
+
+// storeToken 保存新 token, version 为刷新前已保存 token 的版本号
+func (tm *tokenManager) storeToken(ctx context.Context, version int64, token *Token) error {
+	// 旧 refreshToken 已失效, 即使保存失败也要使用新 token
+	err := tm.store.CompareAndSwap(ctx, version, token)
+	if token.AccessToken != "" {
+		tm.useToken(token)
+	}
+	if err != nil {
+		return errors.Wrap(err, "保存 token 失败")
+	}
+
+	return nil
+}
+
+// saveLoginToken 保存登录获取的 token, 覆盖已保存的 token
+func (tm *tokenManager) saveLoginToken(ctx context.Context, token *Token) error {
+	tm.refreshMu.Lock()
+	defer tm.refreshMu.Unlock()
+
+	var version int64
+
+	old, err := tm.store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		version = old.Version
+		if token.ImportedHash == "" {
+			token.ImportedHash = old.ImportedHash
+		}
+	}
+
+	return tm.storeToken(ctx, version, token)
+}
+
+// start 在 accessToken 过期前主动刷新, 刷新失败时退避重试并告警
+func (tm *tokenManager) start() {
+	go func() {
+		backoff := minRefreshBackoff
+
+		for {
+			wait := time.Hour
+			if token := tm.currentToken(); token != nil {
+				wait = time.Until(token.ExpireAt.Add(-tokenExpireMargin))
+			}
+			if tm.failures > 0 {
+				wait = backoff
+			}
+			if wait < time.Second {
+				wait = time.Second
+			}
+			time.Sleep(wait)
+
+			// 未登录, 或 token 已被其他协程刷新
+			token := tm.currentToken()
+			if token == nil || (tm.failures == 0 && token.valid()) {
+				continue
+			}
+
+			err := tm.refresh(context.Background(), nil)
+			if err == nil {
+				if tm.failures > 0 {
+					logger.Infof("刷新 token 恢复正常")
+				}
+				tm.failures = 0
+				backoff = minRefreshBackoff
+				logger.Infof("自动刷新 token 成功")
+				continue
+			}
+
+			tm.failures++
+			logger.Warnf("自动刷新 token 失败(第 %d 次), %s 后重试: %v", tm.failures, backoff, err)
+			if tm.failures >= refreshAlertThreshold {
+				tm.alert(fmt.Sprintf("阿里云盘 token 已连续刷新失败 %d 次, 最近一次错误: %v", tm.failures, err))
+			}
+
+			backoff = backoff * 2
+			if backoff > maxRefreshBackoff {
+				backoff = maxRefreshBackoff
+			}
+		}
+	}()
+}
+
+// alert 告警, 配置了 webhook 时同时推送告警内容
+func (tm *tokenManager) alert(message string) {
+	logger.Errorf("[告警] %s", message)
+
+	if tm.alertWebhook == "" {
+		return
+	}
+
+	resp, err := restyClient.R().
+		SetBody(map[string]string{"text": message}).
+		Post(tm.alertWebhook)
+	if err != nil {
+		logger.Warnf("推送告警失败: %v", err)
+	} else if resp.StatusCode() >= 300 {
+		logger.Warnf("推送告警失败, 状态码: %d", resp.StatusCode())
+	}
+}
+
+// call 调用开放平台接口, 遇到 accessToken 无效或过期时刷新 token 并重试一次
+func (fs *FileSystem) call(ctx context.Context, fn func(client *alipanopen.Client) error) error {
+	client, err := fs.tokens.getClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(client)
+	if !isAccessTokenError(err) {
+		return err
+	}
+
+	logger.Warnf("accessToken 已失效, 刷新后重试: %v", err)
+	if err := fs.tokens.refresh(ctx, client); err != nil {
+		return errors.Wrap(err, "刷新 token 失败")
+	}
+
+	client, err = fs.tokens.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	return fn(client)
+}
@@ -0,0 +1,502 @@
+package adrive
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+)
+
+const (
+	TOKEN_STORE_BOLT = "bolt"
+	TOKEN_STORE_FILE = "file"
+	TOKEN_STORE_HTTP = "http"
+)
+
+var ErrTokenConflict = fmt.Errorf("token 已被其他实例更新")
+
+// Token 认证信息. 多实例共享时一并保存 accessToken, 其他实例可直接使用而无需刷新.
+type Token struct {
+	RefreshToken string    `json:"refreshToken"`
+	AccessToken  string    `json:"accessToken"`
+	ExpireAt     time.Time `json:"expireAt"`
+
+	// 最近一次导入的 refreshToken 的哈希, 启动时据此判断环境变量中的 refreshToken 是否已导入过
+	ImportedHash string `json:"importedHash,omitempty"`
+
+	// 版本号, 每次写入加一, 用于 CompareAndSwap
+	Version int64 `json:"version"`
+}
+
+// TokenStore token 存储. 多个实例共用同一个 refreshToken 时, 通过租约保证同一时间只有一个实例刷新 token.
+type TokenStore interface {
+	// Load 读取 token, 不存在时返回 nil
+	Load(ctx context.Context) (*Token, error)
+	// CompareAndSwap 仅当已保存 token 的版本号为 version 时写入 token, 写入后 token.Version 为 version+1;
+	// 版本号不一致时返回 ErrTokenConflict
+	CompareAndSwap(ctx context.Context, version int64, token *Token) error
+	// AcquireLease 获取刷新租约, 租约被其他 holder 持有且未过期时返回 false
+	AcquireLease(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease 释放 holder 持有的租约
+	ReleaseLease(ctx context.Context, holder string) error
+}
+
+type tokenLease struct {
+	Holder   string    `json:"holder"`
+	ExpireAt time.Time `json:"expireAt"`
+}
+
+func (lease *tokenLease) heldByOther(holder string, now time.Time) bool {
+	return lease != nil && lease.Holder != holder && now.Before(lease.ExpireAt)
+}
+
+func encodeToken(token *Token, cipher *TokenCipher) (string, error) {
+	bs, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+
+	if cipher == nil {
+		return string(bs), nil
+	}
+	return cipher.Encrypt(string(bs))
+}
+
+func decodeToken(v string, cipher *TokenCipher) (*Token, error) {
+	if isEncryptedValue(v) {
+		if cipher == nil {
+			return nil, ErrTokenKeyRequired
+		}
+
+		plaintext, err := cipher.Decrypt(v)
+		if err != nil {
+			return nil, err
+		}
+		v = plaintext
+	}
+
+	token := &Token{}
+	if err := json.Unmarshal([]byte(v), token); err != nil {
+		return nil, errors.Wrap(err, "解析 token 失败")
+	}
+	return token, nil
+}
+
+var _ TokenStore = &BoltTokenStore{}
+
+// BoltTokenStore 将 token 保存在本地数据库中, 适用于单实例. name 不为空时用于区分多个账号.
+type BoltTokenStore struct {
+	db   *DB
+	name string
+}
+
+func NewBoltTokenStore(db *DB, name string) *BoltTokenStore {
+	return &BoltTokenStore{db: db, name: name}
+}
+
+// key 多账号时每个账号使用不同的 key
+func (store *BoltTokenStore) key(key string) string {
+	if store.name == "" {
+		return key
+	}
+	return key + ":" + store.name
+}
+
+func (store *BoltTokenStore) Load(ctx context.Context) (*Token, error) {
+	v, err := store.db.readSecret(store.key(tokenKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if v != "" {
+		return decodeToken(v, nil)
+	}
+
+	if store.name != "" {
+		return nil, nil
+	}
+
+	// 兼容旧版本只保存了 refreshToken 的数据库
+	refreshToken, err := store.db.readSecret(refreshTokenKey)
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken == "" {
+		return nil, nil
+	}
+	return &Token{RefreshToken: refreshToken}, nil
+}
+
+func (store *BoltTokenStore) CompareAndSwap(ctx context.Context, version int64, token *Token) error {
+	return store.db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+
+		var current int64
+		if v := b.Get([]byte(store.key(tokenKey))); v != nil {
+			plaintext, err := store.db.decodeSecret(store.key(tokenKey), string(v))
+			if err != nil {
+				return err
+			}
+			old, err := decodeToken(plaintext, nil)
+			if err != nil {
+				return err
+			}
+			current = old.Version
+		}
+		if current != version {
+			return ErrTokenConflict
+		}
+
+		token.Version = version + 1
+		v, err := encodeToken(token, nil)
+		if err != nil {
+			return err
+		}
+		v, err = store.db.encodeSecret(v)
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put([]byte(store.key(tokenKey)), []byte(v)); err != nil {
+			return err
+		}
+		if store.name != "" {
+			return nil
+		}
+		return b.Delete([]byte(refreshTokenKey))
+	})
+}
+
+func (store *BoltTokenStore) AcquireLease(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	acquired := false
+
+	err := store.db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+
+		now := time.Now()
+		if v := b.Get([]byte(store.key(tokenLeaseKey))); v != nil {
+			lease := &tokenLease{}
+			if err := json.Unmarshal(v, lease); err == nil && lease.heldByOther(holder, now) {
+				return nil
+			}
+		}
+
+		v, err := json.Marshal(&tokenLease{Holder: holder, ExpireAt: now.Add(ttl)})
+		if err != nil {
+			return err
+		}
+		acquired = true
+		return b.Put([]byte(store.key(tokenLeaseKey)), v)
+	})
+
+	return acquired, err
+}
+
+func (store *BoltTokenStore) ReleaseLease(ctx context.Context, holder string) error {
+	return store.db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+
+		if v := b.Get([]byte(store.key(tokenLeaseKey))); v != nil {
+			lease := &tokenLease{}
+			if err := json.Unmarshal(v, lease); err == nil && lease.Holder != holder {
+				return nil
+			}
+		}
+		return b.Delete([]byte(store.key(tokenLeaseKey)))
+	})
+}
+
+var _ TokenStore = &FileTokenStore{}
+
+// FileTokenStore 将 token 保存在文件中, 多个实例可通过共享存储(如 NFS)共用同一文件.
+// 文件 <path>.lock 用于互斥写入, <path>.lease 保存刷新租约.
+type FileTokenStore struct {
+	path   string
+	cipher *TokenCipher
+
+	mu sync.Mutex
+}
+
+func NewFileTokenStore(path string, cipher *TokenCipher) *FileTokenStore {
+	return &FileTokenStore{
+		path:   path,
+		cipher: cipher,
+	}
+}
+
+// 超过该时间未释放的 lock 文件视为残留
+const staleTokenLockDuration = 30 * time.Second
+
+// withLock 通过独占创建 lock 文件实现跨进程互斥, 超过 30 秒未释放的 lock 文件视为残留
+func (store *FileTokenStore) withLock(ctx context.Context, fn func() error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return err
+	}
+
+	lockPath := store.path + ".lock"
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		if stat, err := os.Stat(lockPath); err == nil && time.Since(stat.ModTime()) > staleTokenLockDuration {
+			reclaimStaleLock(lockPath, stat)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	defer os.Remove(lockPath)
+
+	return fn()
+}
+
+// reclaimStaleLock 删除残留的 lock 文件. 先重命名为唯一的文件名, 重命名是原子的, 多个进程同时清理时只有一个能拿到该文件;
+// 拿到的不是 stat 时的文件(已被其他进程清理并重新创建)时放回, Link 不会覆盖已存在的文件.
+func reclaimStaleLock(lockPath string, stat os.FileInfo) {
+	stalePath := fmt.Sprintf("%s.stale-%d-%d", lockPath, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(lockPath, stalePath); err != nil {
+		return
+	}
+	defer os.Remove(stalePath)
+
+	renamed, err := os.Stat(stalePath)
+	// inode 可能被复用, 同时比较修改时间
+	if err == nil && !(os.SameFile(stat, renamed) && stat.ModTime().Equal(renamed.ModTime())) {
+		if err := os.Link(stalePath, lockPath); err != nil {
+			logger.Warnf("恢复 token 锁文件 '%s' 失败: %v", lockPath, err)
+		}
+		return
+	}
+
+	logger.Warnf("删除残留的 token 锁文件 '%s'", lockPath)
+}
+
+// writeFile 先写临时文件再重命名, 保证读取方不会读到写了一半的内容
+func (store *FileTokenStore) writeFile(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (store *FileTokenStore) load() (*Token, error) {
+	bs, err := os.ReadFile(store.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return decodeToken(strings.TrimSpace(string(bs)), store.cipher)
+}
+
+func (store *FileTokenStore) Load(ctx context.Context) (*Token, error) {
+	return store.load()
+}
+
+func (store *FileTokenStore) CompareAndSwap(ctx context.Context, version int64, token *Token) error {
+	return store.withLock(ctx, func() error {
+		old, err := store.load()
+		if err != nil {
+			return err
+		}
+
+		var current int64
+		if old != nil {
+			current = old.Version
+		}
+		if current != version {
+			return ErrTokenConflict
+		}
+
+		token.Version = version + 1
+		v, err := encodeToken(token, store.cipher)
+		if err != nil {
+			return err
+		}
+
+		return store.writeFile(store.path, []byte(v))
+	})
+}
+
+func (store *FileTokenStore) AcquireLease(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	acquired := false
+
+	err := store.withLock(ctx, func() error {
+		leasePath := store.path + ".lease"
+
+		now := time.Now()
+		if bs, err := os.ReadFile(leasePath); err == nil {
+			lease := &tokenLease{}
+			if err := json.Unmarshal(bs, lease); err == nil && lease.heldByOther(holder, now) {
+				return nil
+			}
+		}
+
+		bs, err := json.Marshal(&tokenLease{Holder: holder, ExpireAt: now.Add(ttl)})
+		if err != nil {
+			return err
+		}
+		acquired = true
+		return store.writeFile(leasePath, bs)
+	})
+
+	return acquired, err
+}
+
+func (store *FileTokenStore) ReleaseLease(ctx context.Context, holder string) error {
+	return store.withLock(ctx, func() error {
+		leasePath := store.path + ".lease"
+
+		if bs, err := os.ReadFile(leasePath); err == nil {
+			lease := &tokenLease{}
+			if err := json.Unmarshal(bs, lease); err == nil && lease.Holder != holder {
+				return nil
+			}
+		}
+
+		err := os.Remove(leasePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+var _ TokenStore = &HttpTokenStore{}
+
+// HttpTokenStore 通过 HTTP 接口读写 token, 接口约定:
+//
+//	GET    {url}/token               200 {"version": 1, "data": "..."}, 不存在时 404
+//	PUT    {url}/token               请求 {"version": 1, "data": "..."}, version 为期望的旧版本号, 冲突时 409
+//	POST   {url}/lease               请求 {"holder": "...", "ttl": 60}, 被其他 holder 持有时 409
+//	DELETE {url}/lease?holder=...
+//
+// data 为 token 的 JSON, 配置密钥时为加密后的内容.
+type HttpTokenStore struct {
+	url     string
+	headers map[string]string
+	cipher  *TokenCipher
+}
+
+type httpTokenBody struct {
+	Version int64  `json:"version"`
+	Data    string `json:"data"`
+}
+
+type httpLeaseBody struct {
+	Holder string `json:"holder"`
+	Ttl    int64  `json:"ttl"`
+}
+
+func NewHttpTokenStore(url string, headers map[string]string, cipher *TokenCipher) *HttpTokenStore {
+	return &HttpTokenStore{
+		url:     strings.TrimRight(url, "/"),
+		headers: headers,
+		cipher:  cipher,
+	}
+}
+
+func (store *HttpTokenStore) Load(ctx context.Context) (*Token, error) {
+	body := &httpTokenBody{}
+	resp, err := restyClient.R().SetContext(ctx).SetHeaders(store.headers).SetResult(body).Get(store.url + "/token")
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("读取 token 失败, 状态码: %d, %s", resp.StatusCode(), resp.String())
+	}
+
+	token, err := decodeToken(body.Data, store.cipher)
+	if err != nil {
+		return nil, err
+	}
+	token.Version = body.Version
+	return token, nil
+}
+
+func (store *HttpTokenStore) CompareAndSwap(ctx context.Context, version int64, token *Token) error {
+	token.Version = version + 1
+	data, err := encodeToken(token, store.cipher)
+	if err != nil {
+		return err
+	}
+
+	// 重试时第一次请求可能已写入成功, 导致误报冲突
+	resp, err := noRetryRestyClient.R().SetContext(ctx).SetHeaders(store.headers).
+		SetBody(&httpTokenBody{Version: version, Data: data}).
+		Put(store.url + "/token")
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return ErrTokenConflict
+	default:
+		return fmt.Errorf("写入 token 失败, 状态码: %d, %s", resp.StatusCode(), resp.String())
+	}
+}
+
+func (store *HttpTokenStore) AcquireLease(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	resp, err := restyClient.R().SetContext(ctx).SetHeaders(store.headers).
+		SetBody(&httpLeaseBody{Holder: holder, Ttl: int64(ttl.Seconds())}).
+		Post(store.url + "/lease")
+	if err != nil {
+		return false, err
+	}
+
+	switch resp.StatusCode() {
+	case http.StatusOK, http.StatusNoContent:
+		return true, nil
+	case http.StatusConflict, http.StatusLocked:
+		return false, nil
+	default:
+		return false, fmt.Errorf("获取租约失败, 状态码: %d, %s", resp.StatusCode(), resp.String())
+	}
+}
+
+func (store *HttpTokenStore) ReleaseLease(ctx context.Context, holder string) error {
+	resp, err := restyClient.R().SetContext(ctx).SetHeaders(store.headers).
+		SetQueryParam("holder", holder).
+		Delete(store.url + "/lease")
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode() >= 300 && resp.StatusCode() != http.StatusNotFound {
+		return fmt.Errorf("释放租约失败, 状态码: %d, %s", resp.StatusCode(), resp.String())
+	}
+	return nil
+}
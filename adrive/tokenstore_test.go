@@ -0,0 +1,179 @@
+package adrive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestFileTokenStore(t *testing.T, cipher *TokenCipher) *FileTokenStore {
+	return NewFileTokenStore(filepath.Join(t.TempDir(), "token", "token.json"), cipher)
+}
+
+func TestFileTokenStoreCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	cipher, _ := NewTokenCipher("secret", []byte("salt"))
+
+	for _, c := range []*TokenCipher{nil, cipher} {
+		store := newTestFileTokenStore(t, c)
+
+		token, err := store.Load(ctx)
+		if err != nil || token != nil {
+			t.Fatalf("Load() empty store = %v, %v, want nil, nil", token, err)
+		}
+
+		steps := []struct {
+			version      int64
+			refreshToken string
+			wantErr      error
+			wantVersion  int64
+		}{
+			{0, "a", nil, 1},
+			{0, "b", ErrTokenConflict, 1},
+			{1, "c", nil, 2},
+			{1, "d", ErrTokenConflict, 2},
+			{3, "e", ErrTokenConflict, 2},
+		}
+
+		want := ""
+		for _, step := range steps {
+			err := store.CompareAndSwap(ctx, step.version, &Token{RefreshToken: step.refreshToken})
+			if err != step.wantErr {
+				t.Fatalf("CompareAndSwap(%d, %q) error = %v, want %v", step.version, step.refreshToken, err, step.wantErr)
+			}
+			if err == nil {
+				want = step.refreshToken
+			}
+
+			token, err := store.Load(ctx)
+			if err != nil {
+				t.Fatalf("Load() error: %v", err)
+			}
+			if token.RefreshToken != want || token.Version != step.wantVersion {
+				t.Errorf("Load() = %q v%d, want %q v%d", token.RefreshToken, token.Version, want, step.wantVersion)
+			}
+		}
+	}
+}
+
+func TestFileTokenStoreEncrypted(t *testing.T) {
+	ctx := context.Background()
+	cipher, _ := NewTokenCipher("secret", []byte("salt"))
+	store := newTestFileTokenStore(t, cipher)
+
+	if err := store.CompareAndSwap(ctx, 0, &Token{RefreshToken: "a"}); err != nil {
+		t.Fatalf("CompareAndSwap() error: %v", err)
+	}
+
+	_, err := NewFileTokenStore(store.path, nil).Load(ctx)
+	if err != ErrTokenKeyRequired {
+		t.Errorf("Load() without key error = %v, want %v", err, ErrTokenKeyRequired)
+	}
+
+	other, _ := NewTokenCipher("other", []byte("salt"))
+	_, err = NewFileTokenStore(store.path, other).Load(ctx)
+	if err != ErrWrongTokenKey {
+		t.Errorf("Load() with wrong key error = %v, want %v", err, ErrWrongTokenKey)
+	}
+}
+
+func TestFileTokenStoreLease(t *testing.T) {
+	ctx := context.Background()
+	store := newTestFileTokenStore(t, nil)
+
+	steps := []struct {
+		action string
+		holder string
+		ttl    time.Duration
+		want   bool
+	}{
+		{"acquire", "a", time.Minute, true},
+		{"acquire", "a", time.Minute, true},
+		{"acquire", "b", time.Minute, false},
+		{"release", "b", 0, false},
+		{"acquire", "b", time.Minute, false},
+		{"release", "a", 0, false},
+		{"acquire", "b", -time.Second, true},
+		{"acquire", "a", time.Minute, true},
+	}
+
+	for i, step := range steps {
+		if step.action == "release" {
+			if err := store.ReleaseLease(ctx, step.holder); err != nil {
+				t.Fatalf("step %d: ReleaseLease(%q) error: %v", i, step.holder, err)
+			}
+			continue
+		}
+
+		acquired, err := store.AcquireLease(ctx, step.holder, step.ttl)
+		if err != nil {
+			t.Fatalf("step %d: AcquireLease(%q) error: %v", i, step.holder, err)
+		}
+		if acquired != step.want {
+			t.Errorf("step %d: AcquireLease(%q) = %v, want %v", i, step.holder, acquired, step.want)
+		}
+	}
+}
+
+func TestTokenLeaseHeldByOther(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		lease  *tokenLease
+		holder string
+		want   bool
+	}{
+		{nil, "a", false},
+		{&tokenLease{Holder: "a", ExpireAt: now.Add(time.Minute)}, "a", false},
+		{&tokenLease{Holder: "b", ExpireAt: now.Add(time.Minute)}, "a", true},
+		{&tokenLease{Holder: "b", ExpireAt: now.Add(-time.Minute)}, "a", false},
+	}
+
+	for i, tt := range tests {
+		if got := tt.lease.heldByOther(tt.holder, now); got != tt.want {
+			t.Errorf("case %d: heldByOther() = %v, want %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestFileTokenStoreReclaimStaleLock(t *testing.T) {
+	ctx := context.Background()
+	store := newTestFileTokenStore(t, nil)
+	lockPath := store.path + ".lock"
+
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lockPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	stat, _ := os.Stat(lockPath)
+	reclaimStaleLock(lockPath, stat)
+	time.Sleep(10 * time.Millisecond)
+	os.WriteFile(lockPath+".new", nil, 0600)
+	newStat, _ := os.Stat(lockPath + ".new")
+	if _, err := os.Stat(lockPath); err == nil {
+		t.Fatalf("reclaimStaleLock() did not remove the lock file")
+	}
+
+	// 清理时 lock 文件已被替换则放回
+	os.Rename(lockPath+".new", lockPath)
+	reclaimStaleLock(lockPath, stat)
+	if restored, err := os.Stat(lockPath); err != nil || !os.SameFile(restored, newStat) {
+		t.Fatalf("reclaimStaleLock() removed a lock file created by another process: %v", err)
+	}
+
+	old := time.Now().Add(-time.Minute)
+	os.Chtimes(lockPath, old, old)
+	if err := store.CompareAndSwap(ctx, 0, &Token{RefreshToken: "a"}); err != nil {
+		t.Fatalf("CompareAndSwap() with stale lock error: %v", err)
+	}
+
+	matches, _ := filepath.Glob(lockPath + "*")
+	if len(matches) != 0 {
+		t.Errorf("lock files left: %v", matches)
+	}
+}
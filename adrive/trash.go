@@ -0,0 +1,254 @@
+package adrive
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isayme/go-alipanopen"
+	"github.com/isayme/go-logger"
+	"github.com/pkg/errors"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	trashListUri    = "/adrive/v1.0/openFile/recyclebin/list"
+	trashRestoreUri = "/adrive/v1.0/openFile/recyclebin/restore"
+)
+
+// 回收站列表缓存时间
+const trashCacheDuration = 10 * time.Second
+const trashCacheKey = "trash"
+
+type trashListReq struct {
+	DriveId string `json:"drive_id"`
+	Limit   int    `json:"limit"`
+	Marker  string `json:"marker,omitempty"`
+}
+
+type trashListResp struct {
+	Items      []*alipanopen.File `json:"items"`
+	NextMarker string             `json:"next_marker"`
+}
+
+type trashRestoreReq struct {
+	DriveId string `json:"drive_id"`
+	FileId  string `json:"file_id"`
+}
+
+// isTrashPath name 是否为回收站虚拟文件夹或其中的文件
+func (fs *FileSystem) isTrashPath(name string) bool {
+	return fs.trashDir != "" && (name == fs.trashDir || strings.HasPrefix(name, fs.trashDir+"/"))
+}
+
+// listTrash 列举回收站中原位置在挂载点下的文件, key 为显示的文件名, 重名时文件名后加上文件 ID
+func (fs *FileSystem) listTrash(ctx context.Context) (map[string]*resolvedFile, error) {
+	if v, ok := fs.cache.Get(trashCacheKey); ok {
+		return v.(map[string]*resolvedFile), nil
+	}
+
+	result, err, _ := fs.sg.Do(trashCacheKey, func() (interface{}, error) {
+		var items []*alipanopen.File
+		for _, driveId := range fs.mountedDriveIds() {
+			marker := ""
+			for {
+				respBody := &trashListResp{}
+				err := fs.openApiPost(ctx, trashListUri, &trashListReq{DriveId: driveId, Limit: 100, Marker: marker}, respBody)
+				if err != nil {
+					return nil, errors.Wrap(err, "列举回收站失败")
+				}
+
+				items = append(items, respBody.Items...)
+				if respBody.NextMarker == "" {
+					break
+				}
+				marker = respBody.NextMarker
+			}
+		}
+
+		resolved, err := fs.resolveFiles(ctx, items, len(items))
+		if err != nil {
+			return nil, err
+		}
+
+		files := uniqueResolvedFiles(resolved)
+		fs.cache.Set(trashCacheKey, files, trashCacheDuration)
+		return files, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(map[string]*resolvedFile), nil
+}
+
+// trashFileInfo 回收站中的文件, 文件名为显示的文件名
+func (fs *FileSystem) trashFileInfo(name string, file *alipanopen.File) *FileInfo {
+	f := *file
+	f.FileName = name
+	return NewFileInfo(&f, 0440)
+}
+
+// getTrashFile 获取回收站中的文件, name 为回收站下的相对路径, 只支持第一层
+func (fs *FileSystem) getTrashFile(ctx context.Context, name string) (*resolvedFile, error) {
+	rel := strings.TrimPrefix(name, fs.trashDir+"/")
+	if strings.Contains(rel, "/") {
+		return nil, os.ErrNotExist
+	}
+
+	files, err := fs.listTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, ok := files[rel]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
+
+func (fs *FileSystem) statTrash(ctx context.Context, name string) (*FileInfo, error) {
+	if name == fs.trashDir {
+		return newVirtualDirInfo(path.Base(name)), nil
+	}
+
+	file, err := fs.getTrashFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.trashFileInfo(path.Base(name), file.file), nil
+}
+
+// openTrashFile 回收站只能列举和读取, 其中的文件夹不能展开
+func (fs *FileSystem) openTrashFile(ctx context.Context, name string, flag int) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, os.ErrPermission
+	}
+
+	if name == fs.trashDir {
+		files, err := fs.listTrash(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		children := make([]os.FileInfo, 0, len(files))
+		for childName, file := range files {
+			children = append(children, fs.trashFileInfo(childName, file.file))
+		}
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].Name() < children[j].Name()
+		})
+
+		return &virtualDirFile{fi: newVirtualDirInfo(path.Base(name)), children: children}, nil
+	}
+
+	file, err := fs.getTrashFile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fi := fs.trashFileInfo(path.Base(name), file.file)
+	if fi.IsDir() {
+		return &virtualDirFile{fi: fi}, nil
+	}
+	return NewReadableFile(fi, fs), nil
+}
+
+// purgeTrashFile 从回收站彻底删除, 只允许明确的删除请求, 避免 MOVE/COPY 覆盖时误删
+func (fs *FileSystem) purgeTrashFile(ctx context.Context, name string) error {
+	if name == fs.trashDir || !isExplicitDelete(ctx) {
+		return os.ErrPermission
+	}
+
+	trashFile, err := fs.getTrashFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkMountWritable(trashFile.name); err != nil {
+		return err
+	}
+
+	file := trashFile.file
+
+	err = fs.call(ctx, func(client *alipanopen.Client) error {
+		return client.DeleteFile(ctx, &alipanopen.DeleteFileReq{
+			DriveId: file.DriveId,
+			FileId:  file.FileId,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.cache.Delete(trashCacheKey)
+	logger.Infof("从回收站彻底删除 '%s' 成功", file.FileName)
+	return nil
+}
+
+// restoreTrashFile 从回收站恢复到原位置, newName 与原位置不同时再移动到 newName
+func (fs *FileSystem) restoreTrashFile(ctx context.Context, name, newName string) error {
+	if name == fs.trashDir || fs.isTrashPath(newName) {
+		return os.ErrPermission
+	}
+	if err := fs.checkWritable(newName); err != nil {
+		return err
+	}
+
+	trashFile, err := fs.getTrashFile(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := fs.checkMountWritable(trashFile.name); err != nil {
+		return err
+	}
+	file := trashFile.file
+
+	newParentFolder, err := fs.getFile(ctx, path.Dir(newName))
+	if err != nil {
+		return errors.Wrapf(err, "获取目的父文件夹失败")
+	}
+	if newParentFolder.DriveId != file.DriveId {
+		return ErrCrossDriveMove
+	}
+
+	err = fs.openApiPost(ctx, trashRestoreUri, &trashRestoreReq{DriveId: file.DriveId, FileId: file.FileId}, &struct{}{})
+	if err != nil {
+		return errors.Wrap(err, "从回收站恢复失败")
+	}
+	fs.cache.Delete(trashCacheKey)
+	fs.cleanTrie(newName)
+	fs.forgetFolderFiles(file.DriveId, file.ParentFileId)
+	fs.forgetFolderFiles(newParentFolder.DriveId, newParentFolder.FileId)
+
+	newFileName := path.Base(newName)
+	if newParentFolder.FileId != file.ParentFileId {
+		err = fs.call(ctx, func(client *alipanopen.Client) error {
+			return client.MoveFile(ctx, &alipanopen.MoveFileReq{
+				DriveId:        file.DriveId,
+				FileId:         file.FileId,
+				NewName:        newFileName,
+				ToParentFileId: newParentFolder.FileId,
+				CheckNameMode:  alipanopen.CHECK_NAME_MODE_REFUSE,
+			})
+		})
+	} else if newFileName != file.FileName {
+		err = fs.call(ctx, func(client *alipanopen.Client) error {
+			return client.UpdateFileName(ctx, &alipanopen.UpdateFileNameReq{
+				DriveId:       file.DriveId,
+				FileId:        file.FileId,
+				Name:          newFileName,
+				CheckNameMode: alipanopen.CHECK_NAME_MODE_REFUSE,
+			})
+		})
+	}
+	if err != nil {
+		return errors.Wrap(err, "已恢复到原位置, 移动到目的位置失败")
+	}
+
+	logger.Infof("从回收站恢复 '%s' 到 '%s' 成功", file.FileName, newName)
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 )
 
 var ErrMaxWriteByteExceed = fmt.Errorf("exceed max write byte")
+var errUploadAborted = fmt.Errorf("upload aborted")
 
 type Uploader struct {
 	nw int64
@@ -20,7 +21,7 @@ type Uploader struct {
 	// 当前分片已写入字节, 单分片写入限制最大5G
 	maxWriteBytes int64
 
-	wc io.WriteCloser
+	wc *io.PipeWriter
 
 	uploadEnd chan error
 	lock      sync.Mutex
@@ -120,3 +121,14 @@ func (u *Uploader) CloseAndWait() error {
 
 	return nil
 }
+
+// Abort 中断上传, 已写入的内容不会完整上传
+func (u *Uploader) Abort() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.wc.CloseWithError(errUploadAborted)
+	if u.uploadEnd != nil {
+		<-u.uploadEnd
+	}
+}
@@ -58,12 +58,17 @@ func NewWritableFile(fi *FileInfo, fs *FileSystem) (*WritableFile, error) {
 		Type:          alipanopen.FILE_TYPE_FILE,
 		Size:          0,
 	}
-	respBody, err := writableFile.fs.client.CreateFile(ctx, &reqBody)
+	var respBody *alipanopen.CreateFileResp
+	err := writableFile.fs.call(ctx, func(client *alipanopen.Client) (err error) {
+		respBody, err = client.CreateFile(ctx, &reqBody)
+		return err
+	})
 	if err != nil {
 		logger.Errorf("创建文件 '%s' 失败: %v", writableFile.fi.FileName, err)
 		return nil, err
 	}
 	logger.Infof("创建文件 '%s' 成功", writableFile.fi.FileName)
+	fs.forgetFolderFiles(fi.DriveId, fi.ParentFileId)
 
 	writableFile.fi.FileId = respBody.FileId
 	writableFile.uploadId = respBody.UploadId
@@ -76,16 +81,21 @@ func NewWritableFile(fi *FileInfo, fs *FileSystem) (*WritableFile, error) {
 	return writableFile, nil
 }
 
+// tryDeleteFile 删除上传失败时创建的文件, 只删除本次上传的文件, 不受删除策略限制
 func (writableFile *WritableFile) tryDeleteFile() {
 	reqBody := &alipanopen.DeleteFileReq{
 		DriveId: writableFile.fi.DriveId,
 		FileId:  writableFile.fi.FileId,
 	}
-	err := writableFile.fs.client.DeleteFile(context.Background(), reqBody)
+	ctx := context.Background()
+	err := writableFile.fs.call(ctx, func(client *alipanopen.Client) error {
+		return client.DeleteFile(ctx, reqBody)
+	})
 	if err != nil {
 		logger.Infof("删除文件 '%s' 失败: %v", writableFile.fi.FileName, err)
 	} else {
 		logger.Infof("删除文件 '%s' 成功", writableFile.fi.FileName)
+		writableFile.fs.forgetFolderFiles(writableFile.fi.DriveId, writableFile.fi.ParentFileId)
 	}
 }
 
@@ -110,7 +120,12 @@ func (writableFile *WritableFile) getNextUploader() (err error) {
 			},
 		},
 	}
-	getUploadUrlResp, err := writableFile.fs.client.GetUploadUrl(context.Background(), reqBody)
+	ctx := context.Background()
+	var getUploadUrlResp *alipanopen.GetUploadUrlResp
+	err = writableFile.fs.call(ctx, func(client *alipanopen.Client) (err error) {
+		getUploadUrlResp, err = client.GetUploadUrl(ctx, reqBody)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -184,14 +199,30 @@ func (writableFile *WritableFile) Close() (err error) {
 		}
 	}
 
-	result, err = writableFile.fs.client.CompleteFile(context.Background(), &alipanopen.CompleteFileReq{
-		DriveId:  writableFile.fi.DriveId,
-		FileId:   writableFile.fi.FileId,
-		UploadId: writableFile.uploadId,
+	ctx := context.Background()
+	err = writableFile.fs.call(ctx, func(client *alipanopen.Client) (err error) {
+		result, err = client.CompleteFile(ctx, &alipanopen.CompleteFileReq{
+			DriveId:  writableFile.fi.DriveId,
+			FileId:   writableFile.fi.FileId,
+			UploadId: writableFile.uploadId,
+		})
+		return err
 	})
 	return err
 }
 
+// Abort 放弃上传并删除已创建的文件, 写入中断或失败时使用, 避免 Close 保存不完整的文件
+func (writableFile *WritableFile) Abort() {
+	writableFile.lock.Lock()
+	defer writableFile.lock.Unlock()
+
+	if writableFile.uploader != nil {
+		writableFile.uploader.Abort()
+	}
+	logger.Warnf("放弃上传文件 '%s'", writableFile.fi.FileName)
+	writableFile.tryDeleteFile()
+}
+
 func (writableFile *WritableFile) Read(p []byte) (n int, err error) {
 	return 0, fmt.Errorf("not support")
 }
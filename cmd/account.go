@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/isayme/aliyundrive-webdav/adrive"
+	"github.com/isayme/go-logger"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/webdav"
+)
+
+var loginWithOauth bool
+
+func init() {
+	loginCmd.Flags().BoolVar(&loginWithOauth, "oauth", false, "login with browser authorization instead of qrcode")
+	loginCmd.Flags().Uint16VarP(&listenPort, "port", "p", 8080, "listen port of oauth callback")
+
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+	rootCmd.AddCommand(whoamiCmd)
+}
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "login and save token, then exit",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf := adrive.Get()
+
+		fs, _, err := openFileSystem(conf)
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		if fs.Ready() {
+			logger.Infof("已登录, 如需切换账号请先执行 logout")
+			return printAccountInfo(fs)
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		if loginWithOauth {
+			err = oauthLogin(ctx, fs)
+		} else {
+			err = fs.LoginWithTerminal(ctx)
+		}
+		if err != nil {
+			return fmt.Errorf("登录失败: %v", err)
+		}
+
+		logger.Infof("登录成功, token 已保存")
+		return printAccountInfo(fs)
+	},
+}
+
+// oauthLogin 启动临时服务接收授权回调, 登录成功后停止
+func oauthLogin(ctx context.Context, fs *adrive.FileSystem) error {
+	account, err := selectAccount(adrive.Get())
+	if err != nil {
+		return err
+	}
+	prefix := ""
+	if account.Name != "" {
+		prefix = "/" + account.Name
+	}
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", listenPort),
+		Handler: adrive.NewHandler(fs, webdav.NewMemLS(), prefix),
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	defer server.Close()
+
+	logger.Infof("请在浏览器中打开 http://127.0.0.1:%d%s%soauth/authorize 授权登录", listenPort, prefix, adrive.INTERNAL_PATH_PREFIX)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for !fs.Ready() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errCh:
+			return err
+		case <-ticker.C:
+		}
+	}
+
+	// 等待回调页面跳转完成
+	time.Sleep(time.Second)
+	return nil
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "revoke token and remove local database",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		conf := adrive.Get()
+
+		db, tokenStore, account, err := openStores(conf)
+		if err != nil {
+			return err
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		err = adrive.RevokeToken(ctx, account.AlipanConfig, tokenStore)
+		db.Close()
+		if err != nil {
+			return err
+		}
+
+		// 多账号共用数据库, 只清除当前账号的 token
+		if account.Name == "" {
+			err = adrive.RemoveDB(getDataDir(conf))
+			if err != nil {
+				return fmt.Errorf("删除数据库失败: %v", err)
+			}
+		}
+
+		logger.Infof("已退出登录")
+		return nil
+	},
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "show current account and drive info",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		return printAccountInfo(fs)
+	},
+}
+
+// openReadyFileSystem 打开文件系统, 未登录时返回错误
+func openReadyFileSystem() (*adrive.FileSystem, error) {
+	fs, _, err := openFileSystem(adrive.Get())
+	if err != nil {
+		return nil, err
+	}
+
+	if !fs.Ready() {
+		fs.Close()
+		return nil, fmt.Errorf("未登录, 请先执行 login")
+	}
+
+	return fs, nil
+}
+
+func printAccountInfo(fs *adrive.FileSystem) error {
+	user, driveInfo, err := fs.AccountInfo(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("昵称: %s\n", user.Name)
+	fmt.Printf("ID: %s\n", user.Id)
+	fmt.Printf("默认网盘: %s\n", driveInfo.DefaultDriveId)
+	fmt.Printf("备份盘: %s\n", driveInfo.BackupDriveId)
+	fmt.Printf("资源库: %s\n", driveInfo.ResourceDriveId)
+
+	return nil
+}
@@ -0,0 +1,525 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isayme/aliyundrive-webdav/adrive"
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/spf13/cobra"
+)
+
+var listLong bool
+var listRecursive bool
+var listJson bool
+var putOverwrite bool
+var removeRecursive bool
+var mkdirParents bool
+
+func init() {
+	lsCmd.Flags().BoolVarP(&listLong, "long", "l", false, "use a long listing format")
+	lsCmd.Flags().BoolVarP(&listRecursive, "recursive", "R", false, "list subdirectories recursively")
+	lsCmd.Flags().BoolVar(&listJson, "json", false, "output as json")
+	putCmd.Flags().BoolVarP(&putOverwrite, "overwrite", "f", false, "overwrite existing remote files")
+	rmCmd.Flags().BoolVarP(&removeRecursive, "recursive", "r", false, "remove directories and their contents")
+	mkdirCmd.Flags().BoolVarP(&mkdirParents, "parents", "p", false, "make parent directories as needed, no error if existing")
+
+	for _, cmd := range []*cobra.Command{lsCmd, getCmd, putCmd, mvCmd, rmCmd, mkdirCmd} {
+		// 文件操作命令默认不输出 info 日志, 避免干扰命令输出
+		cmd.Annotations = map[string]string{quietLogAnnotation: "true"}
+		rootCmd.AddCommand(cmd)
+	}
+}
+
+// remotePath 网盘路径, 相对于配置的根目录
+func remotePath(name string) string {
+	return path.Join("/", name)
+}
+
+// readDir 列举网盘文件夹, 按文件名排序
+func readDir(ctx context.Context, fs *adrive.FileSystem, name string) ([]os.FileInfo, error) {
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	all, err := f.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+
+	// 跳过视频转码播放列表等虚拟文件
+	fis := make([]os.FileInfo, 0, len(all))
+	for _, fi := range all {
+		if file, ok := fi.(*adrive.FileInfo); ok && file.IsVirtual() && !file.IsDir() {
+			continue
+		}
+		fis = append(fis, fi)
+	}
+
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	return fis, nil
+}
+
+type fileEntry struct {
+	Path        string    `json:"path"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	IsDir       bool      `json:"isDir"`
+	ModTime     time.Time `json:"modTime"`
+	FileId      string    `json:"fileId,omitempty"`
+	ContentHash string    `json:"contentHash,omitempty"`
+}
+
+func newFileEntry(name string, fi os.FileInfo) *fileEntry {
+	entry := &fileEntry{
+		Path:    name,
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		IsDir:   fi.IsDir(),
+		ModTime: fi.ModTime(),
+	}
+	if file, ok := fi.(*adrive.FileInfo); ok {
+		entry.FileId = file.FileId
+		entry.ContentHash = file.ContentHash
+	}
+	return entry
+}
+
+var lsCmd = &cobra.Command{
+	Use:   "ls [path]",
+	Short: "list directory contents",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		name := "/"
+		if len(args) > 0 {
+			name = remotePath(args[0])
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		fi, err := fs.Stat(ctx, name)
+		if err != nil {
+			return fmt.Errorf("'%s': %v", name, err)
+		}
+
+		entries := []*fileEntry{}
+		err = listFiles(ctx, fs, name, fi, func(entry *fileEntry) {
+			if listJson {
+				entries = append(entries, entry)
+				return
+			}
+
+			display := entry.Name
+			if listRecursive {
+				display = entry.Path
+			}
+			if entry.IsDir {
+				display = display + "/"
+			}
+
+			if listLong {
+				fmt.Printf("%s %10s %s %s\n", modeString(entry.IsDir), util.FormatSize(entry.Size), entry.ModTime.Local().Format("2006-01-02 15:04:05"), display)
+			} else {
+				fmt.Println(display)
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if listJson {
+			fmt.Println(util.Stringify(entries))
+		}
+		return nil
+	},
+}
+
+func modeString(isDir bool) string {
+	if isDir {
+		return "d"
+	}
+	return "-"
+}
+
+// listFiles 列举文件夹, name 为文件时只输出该文件
+func listFiles(ctx context.Context, fs *adrive.FileSystem, name string, fi os.FileInfo, fn func(entry *fileEntry)) error {
+	if !fi.IsDir() {
+		fn(newFileEntry(name, fi))
+		return nil
+	}
+
+	fis, err := readDir(ctx, fs, name)
+	if err != nil {
+		return fmt.Errorf("列举 '%s' 失败: %v", name, err)
+	}
+
+	for _, child := range fis {
+		childName := path.Join(name, child.Name())
+		fn(newFileEntry(childName, child))
+
+		if listRecursive && child.IsDir() {
+			if err := listFiles(ctx, fs, childName, child, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+var getCmd = &cobra.Command{
+	Use:   "get <remote> [local]",
+	Short: "download file or directory",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		remote := remotePath(args[0])
+		local := path.Base(remote)
+		if len(args) > 1 {
+			local = args[1]
+			if st, err := os.Stat(local); err == nil && st.IsDir() {
+				local = filepath.Join(local, path.Base(remote))
+			}
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		return download(ctx, fs, remote, local)
+	},
+}
+
+func download(ctx context.Context, fs *adrive.FileSystem, remote, local string) error {
+	fi, err := fs.Stat(ctx, remote)
+	if err != nil {
+		return fmt.Errorf("'%s': %v", remote, err)
+	}
+
+	if fi.IsDir() {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			return err
+		}
+
+		fis, err := readDir(ctx, fs, remote)
+		if err != nil {
+			return fmt.Errorf("列举 '%s' 失败: %v", remote, err)
+		}
+		for _, child := range fis {
+			err := download(ctx, fs, path.Join(remote, child.Name()), filepath.Join(local, child.Name()))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := fs.OpenFile(ctx, remote, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	out, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+
+	p := newProgress("下载", remote, fi.Size())
+	_, err = io.Copy(out, p.reader(contextReader(ctx, f)))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	p.done(err)
+
+	if err != nil {
+		os.Remove(local)
+		return err
+	}
+
+	return os.Chtimes(local, fi.ModTime(), fi.ModTime())
+}
+
+var putCmd = &cobra.Command{
+	Use:   "put <local> [remote]",
+	Short: "upload file or directory",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		local := args[0]
+		remote := "/"
+		if len(args) > 1 {
+			remote = args[1]
+		}
+		if strings.HasSuffix(remote, "/") {
+			remote = path.Join(remote, filepath.Base(local))
+		} else if fi, err := fs.Stat(ctx, remotePath(remote)); err == nil && fi.IsDir() {
+			remote = path.Join(remote, filepath.Base(local))
+		}
+
+		return upload(ctx, fs, local, remotePath(remote))
+	},
+}
+
+func upload(ctx context.Context, fs *adrive.FileSystem, local, remote string) error {
+	st, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+
+	if st.IsDir() {
+		if err := mkdirAll(ctx, fs, remote); err != nil {
+			return err
+		}
+
+		entries, err := os.ReadDir(local)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err := upload(ctx, fs, filepath.Join(local, entry.Name()), path.Join(remote, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	replace := false
+	if fi, err := fs.Stat(ctx, remote); err == nil {
+		if fi.IsDir() || !putOverwrite {
+			return fmt.Errorf("'%s' 已存在, 覆盖文件请使用 -f", remote)
+		}
+		replace = true
+	}
+
+	in, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return uploadViaTemp(ctx, fs, remote, uploadTempSuffix, replace, func(target string) error {
+		p := newProgress("上传", local, st.Size())
+		err := writeRemoteFile(ctx, fs, target, p.reader(contextReader(ctx, in)))
+		p.done(err)
+		return err
+	})
+}
+
+// 覆盖上传时的临时文件后缀
+const uploadTempSuffix = ".uploading"
+
+// uploadViaTemp 先上传到临时文件 remote+suffix, 成功后重命名为 remote. replace 为 true 时重命名前删除旧文件,
+// 上传中断或失败时删除临时文件, 网盘中的旧文件不受影响.
+func uploadViaTemp(ctx context.Context, fs *adrive.FileSystem, remote, suffix string, replace bool, upload func(target string) error) error {
+	target := remote + suffix
+	// 上次中断时残留的临时文件
+	if _, err := fs.Stat(ctx, target); err == nil {
+		if err := fs.RemoveAll(ctx, target); err != nil {
+			return fmt.Errorf("删除临时文件失败: %v", err)
+		}
+	}
+
+	if err := upload(target); err != nil {
+		fs.RemoveAll(context.Background(), target)
+		return err
+	}
+
+	if replace {
+		if err := fs.RemoveAll(ctx, remote); err != nil {
+			return fmt.Errorf("删除旧文件失败, 新文件已上传为 '%s': %v", target, err)
+		}
+	}
+	if err := fs.Rename(ctx, target, remote); err != nil {
+		return fmt.Errorf("重命名失败, 新文件已上传为 '%s': %v", target, err)
+	}
+	return nil
+}
+
+// aborter 写入失败时可放弃的文件, 如 adrive.WritableFile
+type aborter interface {
+	Abort()
+}
+
+// writeRemoteFile 新建网盘文件并写入 r 的内容, 写入失败或中断时放弃上传, 不保存不完整的文件
+func writeRemoteFile(ctx context.Context, fs *adrive.FileSystem, remote string, r io.Reader) error {
+	f, err := fs.OpenFile(ctx, remote, os.O_CREATE|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	if err != nil {
+		if a, ok := f.(aborter); ok {
+			a.Abort()
+			return err
+		}
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// mkdirAll 创建文件夹及不存在的父文件夹
+func mkdirAll(ctx context.Context, fs *adrive.FileSystem, name string) error {
+	if name == "/" {
+		return nil
+	}
+
+	fi, err := fs.Stat(ctx, name)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("'%s' 已存在且不是文件夹", name)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := mkdirAll(ctx, fs, path.Dir(name)); err != nil {
+		return err
+	}
+	return fs.Mkdir(ctx, name, 0755)
+}
+
+var mvCmd = &cobra.Command{
+	Use:   "mv <source> <dest>",
+	Short: "move or rename file or directory",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		source := remotePath(args[0])
+		dest := remotePath(args[1])
+		if fi, err := fs.Stat(ctx, dest); err == nil && fi.IsDir() {
+			dest = path.Join(dest, path.Base(source))
+		}
+
+		return fs.Rename(ctx, source, dest)
+	},
+}
+
+var rmCmd = &cobra.Command{
+	Use:   "rm <path>...",
+	Short: "move files or directories to trash",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+		ctx = adrive.WithExplicitDelete(ctx)
+
+		for _, arg := range args {
+			name := remotePath(arg)
+			if name == "/" {
+				return fmt.Errorf("不能删除根目录")
+			}
+
+			fi, err := fs.Stat(ctx, name)
+			if err != nil {
+				return fmt.Errorf("'%s': %v", name, err)
+			}
+			if fi.IsDir() && !removeRecursive {
+				return fmt.Errorf("'%s' 是文件夹, 删除文件夹请使用 -r", name)
+			}
+
+			if err := fs.RemoveAll(ctx, name); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+var mkdirCmd = &cobra.Command{
+	Use:   "mkdir <path>...",
+	Short: "create directories",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		for _, arg := range args {
+			name := remotePath(arg)
+			if mkdirParents {
+				err = mkdirAll(ctx, fs, name)
+			} else {
+				err = fs.Mkdir(ctx, name, 0755)
+			}
+			if err != nil {
+				return fmt.Errorf("'%s': %v", name, err)
+			}
+		}
+
+		return nil
+	},
+}
+
+// contextReader ctx 取消后读取返回错误, 用于中断传输
+func contextReader(ctx context.Context, r io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return r.Read(p)
+	})
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (fn readerFunc) Read(p []byte) (int, error) {
+	return fn(p)
+}
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/isayme/aliyundrive-webdav/adrive"
+	"github.com/spf13/cobra"
+)
+
+// 下载中的文件后缀, 下载完成并校验通过后重命名
+const partialFileSuffix = ".adrive-part"
+
+var mirrorChecksum bool
+
+func init() {
+	mirrorCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "n", false, "show what would be done without making changes")
+	mirrorCmd.Flags().BoolVarP(&mirrorChecksum, "checksum", "c", false, "compare SHA1 of existing local files instead of size and mtime")
+	mirrorCmd.Flags().StringArrayVar(&syncIncludes, "include", nil, "only mirror files matching glob, can be repeated")
+	mirrorCmd.Flags().StringArrayVar(&syncExcludes, "exclude", nil, "skip files matching glob, can be repeated")
+	mirrorCmd.Flags().StringVar(&syncReportFile, "report", "", "write json report to file, '-' for stdout")
+
+	mirrorCmd.Annotations = map[string]string{quietLogAnnotation: "true"}
+	rootCmd.AddCommand(mirrorCmd)
+}
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror <remote> <local>",
+	Short: "download drive folder to local directory incrementally",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		remote := remotePath(args[0])
+		local := args[1]
+
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		fi, err := fs.Stat(ctx, remote)
+		if err != nil {
+			return fmt.Errorf("'%s': %v", remote, err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("'%s' 不是文件夹", remote)
+		}
+
+		m := &mirrorer{
+			fs:     fs,
+			filter: &fileFilter{includes: syncIncludes, excludes: syncExcludes},
+			report: &syncReport{
+				Source:  remote,
+				Dest:    local,
+				DryRun:  syncDryRun,
+				StartAt: time.Now(),
+				Actions: []*syncAction{},
+			},
+		}
+
+		err = m.mirrorDir(ctx, remote, local, "")
+		if err != nil {
+			return err
+		}
+
+		return m.report.write(syncReportFile)
+	},
+}
+
+type mirrorer struct {
+	fs     *adrive.FileSystem
+	filter *fileFilter
+	report *syncReport
+}
+
+func (m *mirrorer) mirrorDir(ctx context.Context, remote, local, rel string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !m.report.DryRun {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			return err
+		}
+	}
+
+	fis, err := readDir(ctx, m.fs, remote)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		m.report.add(&syncAction{Path: remote, Action: SYNC_ACTION_DOWNLOAD}, fmt.Errorf("列举文件夹失败: %v", err))
+		return nil
+	}
+
+	for _, fi := range fis {
+		childRel := path.Join(rel, fi.Name())
+		if m.filter.skip(childRel, fi.IsDir()) {
+			continue
+		}
+
+		childRemote := path.Join(remote, fi.Name())
+		childLocal := filepath.Join(local, fi.Name())
+
+		if fi.IsDir() {
+			err = m.mirrorDir(ctx, childRemote, childLocal, childRel)
+		} else {
+			err = m.mirrorFile(ctx, childRemote, childLocal, fi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mirrorFile 下载单个文件, 仅在 ctx 取消时返回错误, 其他错误记录到报告中
+func (m *mirrorer) mirrorFile(ctx context.Context, remote, local string, fi os.FileInfo) error {
+	contentHash := ""
+	if file, ok := fi.(*adrive.FileInfo); ok {
+		contentHash = file.ContentHash
+	}
+
+	if st, err := os.Stat(local); err == nil {
+		if st.IsDir() {
+			m.report.add(&syncAction{Path: local, Action: SYNC_ACTION_DOWNLOAD, Size: fi.Size()}, fmt.Errorf("本地已存在同名文件夹"))
+			return nil
+		}
+		if m.unchanged(local, st, fi, contentHash) {
+			m.report.Skipped++
+			return nil
+		}
+	}
+
+	partial := local + partialFileSuffix
+	action := &syncAction{Path: local, Action: SYNC_ACTION_DOWNLOAD, Size: fi.Size()}
+	if st, err := os.Stat(partial); err == nil && st.Size() > 0 && st.Size() < fi.Size() {
+		action.Action = SYNC_ACTION_RESUME
+	}
+
+	if m.report.DryRun {
+		m.report.add(action, nil)
+		return nil
+	}
+
+	err := m.download(ctx, remote, partial, fi.Size(), contentHash)
+	if err == nil {
+		err = os.Rename(partial, local)
+	}
+	if err == nil {
+		err = os.Chtimes(local, fi.ModTime(), fi.ModTime())
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	m.report.add(action, err)
+	return nil
+}
+
+// unchanged 本地文件是否与网盘一致
+func (m *mirrorer) unchanged(local string, st os.FileInfo, fi os.FileInfo, contentHash string) bool {
+	if st.Size() != fi.Size() {
+		return false
+	}
+
+	if !mirrorChecksum || contentHash == "" {
+		return st.ModTime().Truncate(time.Second).Equal(fi.ModTime().Truncate(time.Second))
+	}
+
+	localHash, err := fileSha1(local)
+	return err == nil && strings.EqualFold(localHash, contentHash)
+}
+
+// download 下载到 partial 文件, 已有部分内容时通过 Range 续传, 完成后校验 SHA1
+func (m *mirrorer) download(ctx context.Context, remote, partial string, size int64, contentHash string) error {
+	out, err := os.OpenFile(partial, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	h := sha1.New()
+
+	// 已下载的部分先计入哈希, 超过文件大小说明内容有误, 重新下载
+	offset, err := io.Copy(h, out)
+	if err != nil {
+		return err
+	}
+	if offset > size {
+		if err := out.Truncate(0); err != nil {
+			return err
+		}
+		offset = 0
+		h.Reset()
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if offset < size {
+		f, err := m.fs.OpenFile(ctx, remote, os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		p := newProgress("下载", remote, size)
+		p.add(int(offset))
+		_, err = io.Copy(io.MultiWriter(out, h), p.reader(contextReader(ctx, f)))
+		p.done(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	if contentHash != "" {
+		actual := strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+		if !strings.EqualFold(actual, contentHash) {
+			out.Close()
+			os.Remove(partial)
+			return fmt.Errorf("SHA1 校验失败, 期望: %s, 实际: %s", contentHash, actual)
+		}
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/isayme/go-ora"
+)
+
+// progress 在终端显示传输进度
+type progress struct {
+	mu      sync.Mutex
+	ora     *ora.Ora
+	action  string
+	name    string
+	total   int64
+	current int64
+	start   time.Time
+	last    time.Time
+}
+
+func newProgress(action, name string, total int64) *progress {
+	p := &progress{
+		ora:    ora.New(),
+		action: action,
+		name:   name,
+		total:  total,
+		start:  time.Now(),
+	}
+	p.ora.Text(p.text())
+	p.ora.Start()
+	return p
+}
+
+func (p *progress) add(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.current = p.current + int64(n)
+
+	// 限制刷新频率
+	now := time.Now()
+	if now.Sub(p.last) < 200*time.Millisecond {
+		return
+	}
+	p.last = now
+	p.ora.Text(p.text())
+}
+
+func (p *progress) text() string {
+	speed := ""
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		speed = fmt.Sprintf(", %s/s", util.FormatSize(int64(float64(p.current)/elapsed)))
+	}
+
+	if p.total > 0 {
+		return fmt.Sprintf("%s '%s' %s / %s (%d%%)%s", p.action, p.name, util.FormatSize(p.current), util.FormatSize(p.total), p.current*100/p.total, speed)
+	}
+	return fmt.Sprintf("%s '%s' %s%s", p.action, p.name, util.FormatSize(p.current), speed)
+}
+
+// done 结束进度显示, err 不为空时显示失败
+func (p *progress) done(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.ora.Fail(fmt.Sprintf("%s '%s' 失败: %v", p.action, p.name, err))
+	} else {
+		p.ora.Succeed(fmt.Sprintf("%s '%s' 完成, %s, 耗时 %s", p.action, p.name, util.FormatSize(p.current), time.Since(p.start).Round(time.Millisecond)))
+	}
+	p.ora.Stop()
+}
+
+func (p *progress) reader(r io.Reader) io.Reader {
+	return &progressReader{r: r, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (pr *progressReader) Read(b []byte) (n int, err error) {
+	n, err = pr.r.Read(b)
+	pr.p.add(n)
+	return
+}
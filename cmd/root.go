@@ -1,9 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/isayme/aliyundrive-webdav/adrive"
 	"github.com/isayme/aliyundrive-webdav/util"
@@ -15,48 +19,170 @@ import (
 var showVersion bool
 var listenPort uint16
 var logLevel string
+var dataDir string
+var importRefreshToken string
+var accountName string
+
+// 带有该注解的命令未指定日志级别时只输出警告及以上日志
+const quietLogAnnotation = "quietLog"
 
 func init() {
 	rootCmd.Flags().Uint16VarP(&listenPort, "port", "p", 8080, "listen port")
-	rootCmd.Flags().StringVarP(&logLevel, "level", "l", "info", "log level")
+	rootCmd.PersistentFlags().StringVarP(&logLevel, "level", "l", "info", "log level")
 	rootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show version")
+	rootCmd.Flags().StringVar(&importRefreshToken, "refresh-token", "", "import refresh token on startup, env REFRESH_TOKEN")
+	rootCmd.PersistentFlags().StringVarP(&dataDir, "data-dir", "d", "", "data directory, env DATA_DIR, default current directory")
+	rootCmd.PersistentFlags().StringVarP(&accountName, "account", "a", "", "account name when multiple accounts configured")
+}
+
+// getDataDir 数据目录优先级: 命令行参数 > 环境变量 > 配置文件 > 当前目录
+func getDataDir(conf *adrive.Config) string {
+	if dataDir != "" {
+		return dataDir
+	}
+
+	if v := os.Getenv("DATA_DIR"); v != "" {
+		return v
+	}
+
+	if conf.DataDir != "" {
+		return conf.DataDir
+	}
+
+	return "."
+}
+
+// signalContext 收到中断信号时取消
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 }
 
 var rootCmd = &cobra.Command{
-	Use: "aliyundrive-webdav",
+	Use:           "aliyundrive-webdav",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		logger.SetFormat("console")
+
+		level := logLevel
+		if cmd.Annotations[quietLogAnnotation] == "true" && !cmd.Flags().Changed("level") {
+			level = "warn"
+		}
+		logger.SetLevel(level)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if showVersion {
 			util.ShowVersion()
 			os.Exit(0)
 		}
 
-		logger.SetFormat("console")
-		logger.SetLevel(logLevel)
-
 		conf := adrive.Get()
 
-		fs, err := adrive.NewFileSystem(conf.AlipanConfig)
+		accounts, db, err := openAccounts(conf)
 		if err != nil {
 			logger.Errorf("启动失败: %v", err)
 			return
 		}
+		defer db.Close()
+
+		var handler http.Handler
+		if len(accounts) == 1 && accounts[0].name == "" {
+			handler = adrive.NewHandler(accounts[0].fs, newLockSystem(conf, db, ""), "")
+		} else {
+			handlers := map[string]*adrive.Handler{}
+			for _, account := range accounts {
+				handlers[account.name] = adrive.NewHandler(account.fs, newLockSystem(conf, db, account.name), "/"+account.name)
+			}
+			handler = adrive.NewMultiHandler(handlers)
+		}
 
 		address := fmt.Sprintf(":%d", listenPort)
-		logger.Infof("服务已启动, 端口: %d ", listenPort)
+		server := &http.Server{
+			Addr:    address,
+			Handler: handler,
+		}
 
-		err = http.ListenAndServe(address, &webdav.Handler{
-			FileSystem: fs,
-			LockSystem: webdav.NewMemLS(),
-		})
-		if err != nil {
+		ctx, stop := signalContext()
+		defer stop()
+
+		refreshToken := importRefreshToken
+		if refreshToken == "" {
+			refreshToken = os.Getenv("REFRESH_TOKEN")
+		}
+		if refreshToken != "" {
+			account, err := findServedAccount(accounts)
+			if err == nil {
+				err = account.fs.ImportRefreshTokenOnce(ctx, refreshToken)
+			}
+			if err != nil {
+				logger.Errorf("启动失败: %v", err)
+				return
+			}
+		}
+
+		for _, account := range accounts {
+			if account.fs.Ready() {
+				continue
+			}
+
+			prefix := ""
+			if account.name != "" {
+				prefix = "/" + account.name
+				logger.Infof("账号 '%s' 未登录", account.name)
+			}
+			logger.Infof("未登录, 请扫描终端中的二维码或访问 http://127.0.0.1:%d%s%slogin 扫码登录", listenPort, prefix, adrive.INTERNAL_PATH_PREFIX)
+			account.fs.StartLogin(ctx)
+		}
+
+		go func() {
+			<-ctx.Done()
+			logger.Infof("服务正在停止...")
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+
+		logger.Infof("服务已启动, 端口: %d ", listenPort)
+		err = server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			logger.Errorf("启动失败: %v", err)
 		}
 	},
 }
 
+// newLockSystem 创建 webdav 锁, name 为多账号时的账号名
+func newLockSystem(conf *adrive.Config, db *adrive.DB, name string) webdav.LockSystem {
+	switch conf.LockConfig.Store {
+	case adrive.LOCK_STORE_MEMORY:
+		return webdav.NewMemLS()
+	default:
+		ls := adrive.NewLockSystem(adrive.NewBoltLockStore(db, name))
+		ls.StartSweep(time.Minute)
+		return ls
+	}
+}
+
+// findServedAccount 导入 refreshToken 的账号, 多账号时需通过 --account 指定
+func findServedAccount(accounts []*servedAccount) (*servedAccount, error) {
+	if accountName == "" {
+		if len(accounts) > 1 {
+			return nil, fmt.Errorf("配置了多个账号, 请通过 --account 指定导入 refreshToken 的账号")
+		}
+		return accounts[0], nil
+	}
+
+	for _, account := range accounts {
+		if account.name == accountName {
+			return account, nil
+		}
+	}
+	return nil, fmt.Errorf("账号 '%s' 不存在", accountName)
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		logger.Panicf("rootCmd execute fail: %s", err.Error())
+		logger.Errorf("%s", err.Error())
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/isayme/aliyundrive-webdav/adrive"
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/spf13/cobra"
+)
+
+var shareExpire string
+var shareCode string
+var shareJson bool
+
+func init() {
+	shareCreateCmd.Flags().StringVar(&shareExpire, "expire", "", "expire duration, such as 24h or 7d, empty means never expire")
+	shareCreateCmd.Flags().StringVar(&shareCode, "code", "", "extraction code of 4 letters or digits, 'random' to generate one")
+	shareLsCmd.Flags().BoolVar(&shareJson, "json", false, "output as json")
+
+	shareCmd.AddCommand(shareCreateCmd)
+	shareCmd.AddCommand(shareLsCmd)
+	shareCmd.AddCommand(shareCancelCmd)
+	for _, cmd := range []*cobra.Command{shareCreateCmd, shareLsCmd, shareCancelCmd} {
+		cmd.Annotations = map[string]string{quietLogAnnotation: "true"}
+	}
+	rootCmd.AddCommand(shareCmd)
+}
+
+var shareCmd = &cobra.Command{
+	Use:   "share",
+	Short: "create, list or cancel share links",
+}
+
+var shareCreateCmd = &cobra.Command{
+	Use:   "create <path>",
+	Short: "create share link of file or directory",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		expire, err := adrive.ParseShareExpire(shareExpire)
+		if err != nil {
+			return err
+		}
+
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		name := remotePath(args[0])
+		share, err := fs.CreateShare(ctx, name, expire, shareCode)
+		if err != nil {
+			return fmt.Errorf("'%s': %v", name, err)
+		}
+
+		printShare(share)
+		return nil
+	},
+}
+
+var shareLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "list share links",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		shares, err := fs.ListShares(ctx)
+		if err != nil {
+			return err
+		}
+
+		if shareJson {
+			fmt.Println(util.Stringify(shares))
+			return nil
+		}
+		for _, share := range shares {
+			printShare(share)
+		}
+		return nil
+	},
+}
+
+var shareCancelCmd = &cobra.Command{
+	Use:   "cancel <share_id>...",
+	Short: "cancel share links",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		for _, shareId := range args {
+			if err := fs.CancelShare(ctx, shareId); err != nil {
+				return fmt.Errorf("'%s': %v", shareId, err)
+			}
+		}
+		return nil
+	},
+}
+
+func printShare(share *adrive.ShareLink) {
+	expiration := share.Expiration
+	if expiration == "" {
+		expiration = "永久有效"
+	}
+	code := share.SharePwd
+	if code == "" {
+		code = "-"
+	}
+
+	fmt.Printf("%s\t%s\t提取码: %s\t有效期: %s\t%s\n", share.ShareId, share.ShareUrl, code, expiration, share.ShareName)
+}
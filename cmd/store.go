@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/isayme/aliyundrive-webdav/adrive"
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+)
+
+var tokenKeyFile string
+var tokenKeyPrompt bool
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&tokenKeyFile, "token-key-file", "", "file containing the token encryption key, env TOKEN_KEY_FILE")
+	rootCmd.PersistentFlags().BoolVar(&tokenKeyPrompt, "token-key-prompt", false, "prompt for the token encryption passphrase")
+}
+
+// getTokenKey 获取 token 加密密钥, 优先级: 环境变量 TOKEN_KEY > 密钥文件 > 交互输入. 未配置时返回空字符串.
+func getTokenKey(conf *adrive.Config) (string, error) {
+	if v := os.Getenv("TOKEN_KEY"); v != "" {
+		return v, nil
+	}
+
+	keyFile := tokenKeyFile
+	if keyFile == "" {
+		keyFile = os.Getenv("TOKEN_KEY_FILE")
+	}
+	if keyFile == "" {
+		keyFile = conf.TokenKeyFile
+	}
+	if keyFile != "" {
+		bs, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", errors.Wrapf(err, "读取密钥文件 '%s' 失败", keyFile)
+		}
+
+		key := strings.TrimSpace(string(bs))
+		if key == "" {
+			return "", fmt.Errorf("密钥文件 '%s' 内容为空", keyFile)
+		}
+		return key, nil
+	}
+
+	if tokenKeyPrompt {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return "", fmt.Errorf("标准输入不是终端, 无法输入密钥")
+		}
+
+		fmt.Fprint(os.Stderr, "请输入 token 加密密钥: ")
+		bs, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", errors.Wrap(err, "读取密钥失败")
+		}
+		return string(bs), nil
+	}
+
+	return "", nil
+}
+
+// openDB 打开数据库, 密钥不为空时启用 token 加密
+func openDB(conf *adrive.Config, key string) (*adrive.DB, error) {
+	db, err := adrive.OpenDB(getDataDir(conf))
+	if err != nil {
+		return nil, err
+	}
+
+	if key != "" {
+		err = db.EnableEncryption(key)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// 共享 token 存储使用固定的盐, 各实例使用相同密钥即可解密
+const sharedTokenKeySalt = "aliyundrive-webdav"
+
+// newTokenStore 创建 token 存储, name 为多账号时的账号名, 每个账号的 token 分开保存
+func newTokenStore(conf *adrive.Config, db *adrive.DB, key string, name string) (adrive.TokenStore, error) {
+	storeConf := conf.TokenStoreConfig
+
+	var cipher *adrive.TokenCipher
+	if key != "" && storeConf.Type != "" && storeConf.Type != adrive.TOKEN_STORE_BOLT {
+		var err error
+		cipher, err = adrive.NewTokenCipher(key, []byte(sharedTokenKeySalt))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch storeConf.Type {
+	case "", adrive.TOKEN_STORE_BOLT:
+		return adrive.NewBoltTokenStore(db, name), nil
+	case adrive.TOKEN_STORE_FILE:
+		if storeConf.Path == "" {
+			return nil, fmt.Errorf("token 存储方式为 file 时需配置 path")
+		}
+		path := storeConf.Path
+		if name != "" {
+			path = path + "." + name
+		}
+		return adrive.NewFileTokenStore(path, cipher), nil
+	case adrive.TOKEN_STORE_HTTP:
+		if storeConf.Url == "" {
+			return nil, fmt.Errorf("token 存储方式为 http 时需配置 url")
+		}
+		url := storeConf.Url
+		if name != "" {
+			url = strings.TrimRight(url, "/") + "/" + name
+		}
+		return adrive.NewHttpTokenStore(url, storeConf.Headers, cipher), nil
+	default:
+		return nil, fmt.Errorf("不支持的 token 存储方式: %s", storeConf.Type)
+	}
+}
+
+// getAccounts 所有账号, 未配置多账号时只有一个名称为空的账号
+func getAccounts(conf *adrive.Config) ([]adrive.AccountConfig, error) {
+	if len(conf.Accounts) == 0 {
+		return []adrive.AccountConfig{{AlipanConfig: conf.AlipanConfig}}, nil
+	}
+
+	return conf.GetAccounts()
+}
+
+// selectAccount 命令行操作的账号, 配置了多个账号时需通过 --account 指定
+func selectAccount(conf *adrive.Config) (adrive.AccountConfig, error) {
+	accounts, err := getAccounts(conf)
+	if err != nil {
+		return adrive.AccountConfig{}, err
+	}
+
+	if accountName == "" {
+		if len(accounts) > 1 {
+			return adrive.AccountConfig{}, fmt.Errorf("配置了多个账号, 请通过 --account 指定")
+		}
+		return accounts[0], nil
+	}
+
+	for _, account := range accounts {
+		if account.Name == accountName {
+			return account, nil
+		}
+	}
+	return adrive.AccountConfig{}, fmt.Errorf("账号 '%s' 不存在", accountName)
+}
+
+// openStores 打开数据库和选中账号的 token 存储
+func openStores(conf *adrive.Config) (*adrive.DB, adrive.TokenStore, adrive.AccountConfig, error) {
+	account, err := selectAccount(conf)
+	if err != nil {
+		return nil, nil, account, err
+	}
+
+	key, err := getTokenKey(conf)
+	if err != nil {
+		return nil, nil, account, err
+	}
+
+	db, err := openDB(conf, key)
+	if err != nil {
+		return nil, nil, account, err
+	}
+
+	tokenStore, err := newTokenStore(conf, db, key, account.Name)
+	if err != nil {
+		db.Close()
+		return nil, nil, account, err
+	}
+
+	return db, tokenStore, account, nil
+}
+
+// openFileSystem 打开选中账号的文件系统, 没有可用 token 时文件系统处于未登录状态
+func openFileSystem(conf *adrive.Config) (*adrive.FileSystem, *adrive.DB, error) {
+	db, tokenStore, account, err := openStores(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fs, err := adrive.NewFileSystem(account.AlipanConfig, db, tokenStore)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return fs, db, nil
+}
+
+// servedAccount 服务中的账号
+type servedAccount struct {
+	name string
+	fs   *adrive.FileSystem
+}
+
+// openAccounts 打开所有账号的文件系统, 共用同一个数据库
+func openAccounts(conf *adrive.Config) ([]*servedAccount, *adrive.DB, error) {
+	accounts, err := getAccounts(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := getTokenKey(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := openDB(conf, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result []*servedAccount
+	for _, account := range accounts {
+		tokenStore, err := newTokenStore(conf, db, key, account.Name)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+
+		fs, err := adrive.NewFileSystem(account.AlipanConfig, db, tokenStore)
+		if err != nil {
+			db.Close()
+			if account.Name != "" {
+				return nil, nil, fmt.Errorf("账号 '%s': %v", account.Name, err)
+			}
+			return nil, nil, err
+		}
+
+		result = append(result, &servedAccount{name: account.Name, fs: fs})
+	}
+
+	return result, db, nil
+}
@@ -0,0 +1,399 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/isayme/aliyundrive-webdav/adrive"
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/isayme/go-logger"
+	"github.com/spf13/cobra"
+)
+
+var syncDelete bool
+var syncDryRun bool
+var syncChecksum bool
+var syncIncludes []string
+var syncExcludes []string
+var syncReportFile string
+
+func init() {
+	syncCmd.Flags().BoolVar(&syncDelete, "delete", false, "delete remote files not existing locally")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "n", false, "show what would be done without making changes")
+	syncCmd.Flags().BoolVarP(&syncChecksum, "checksum", "c", false, "always compare SHA1 even if size and mtime match")
+	syncCmd.Flags().StringArrayVar(&syncIncludes, "include", nil, "only sync files matching glob, can be repeated")
+	syncCmd.Flags().StringArrayVar(&syncExcludes, "exclude", nil, "skip files matching glob, can be repeated")
+	syncCmd.Flags().StringVar(&syncReportFile, "report", "", "write json report to file, '-' for stdout")
+
+	syncCmd.Annotations = map[string]string{quietLogAnnotation: "true"}
+	rootCmd.AddCommand(syncCmd)
+}
+
+// fileFilter 按 glob 过滤文件, 规则同时匹配相对路径和文件名
+type fileFilter struct {
+	includes []string
+	excludes []string
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skip 是否跳过, rel 为 / 分隔的相对路径. 文件夹只应用 exclude 规则.
+func (filter *fileFilter) skip(rel string, isDir bool) bool {
+	if matchAny(filter.excludes, rel) {
+		return true
+	}
+
+	if isDir || len(filter.includes) == 0 {
+		return false
+	}
+	return !matchAny(filter.includes, rel)
+}
+
+const (
+	SYNC_ACTION_UPLOAD       = "upload"
+	SYNC_ACTION_RAPID_UPLOAD = "rapidUpload"
+	SYNC_ACTION_MKDIR        = "mkdir"
+	SYNC_ACTION_DELETE       = "delete"
+	SYNC_ACTION_DOWNLOAD     = "download"
+	SYNC_ACTION_RESUME       = "resume"
+)
+
+type syncAction struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Size   int64  `json:"size"`
+	Error  string `json:"error,omitempty"`
+}
+
+// syncReport 同步结果, 通过 --report 输出为 JSON
+type syncReport struct {
+	Source  string    `json:"source"`
+	Dest    string    `json:"dest"`
+	DryRun  bool      `json:"dryRun"`
+	StartAt time.Time `json:"startAt"`
+	EndAt   time.Time `json:"endAt"`
+
+	Transferred int   `json:"transferred"`
+	Deleted     int   `json:"deleted"`
+	Skipped     int   `json:"skipped"`
+	Failed      int   `json:"failed"`
+	Bytes       int64 `json:"bytes"`
+
+	Actions []*syncAction `json:"actions"`
+}
+
+func (report *syncReport) add(action *syncAction, err error) {
+	if err != nil {
+		action.Error = err.Error()
+		report.Failed++
+		logger.Errorf("%s '%s' 失败: %v", action.Action, action.Path, err)
+	} else {
+		switch action.Action {
+		case SYNC_ACTION_DELETE:
+			report.Deleted++
+		case SYNC_ACTION_MKDIR:
+		default:
+			report.Transferred++
+			report.Bytes = report.Bytes + action.Size
+		}
+		if report.DryRun {
+			fmt.Printf("[dry-run] %s %s\n", action.Action, action.Path)
+		}
+	}
+
+	report.Actions = append(report.Actions, action)
+}
+
+func (report *syncReport) write(file string) error {
+	report.EndAt = time.Now()
+
+	fmt.Fprintf(os.Stderr, "传输 %d 个文件(%s), 删除 %d 个, 跳过 %d 个, 失败 %d 个\n",
+		report.Transferred, util.FormatSize(report.Bytes), report.Deleted, report.Skipped, report.Failed)
+
+	if file != "" {
+		content := util.Stringify(report)
+		if file == "-" {
+			fmt.Println(content)
+		} else if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			return fmt.Errorf("写入报告失败: %v", err)
+		}
+	}
+
+	if report.Failed > 0 {
+		return fmt.Errorf("%d 个文件同步失败", report.Failed)
+	}
+	return nil
+}
+
+// fileSha1 计算本地文件 SHA1, 与网盘 ContentHash 格式一致(大写)
+func fileSha1(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync <local> <remote>",
+	Short: "one-way sync local directory to drive folder",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		local := args[0]
+		remote := remotePath(args[1])
+
+		st, err := os.Stat(local)
+		if err != nil {
+			return err
+		}
+		if !st.IsDir() {
+			return fmt.Errorf("'%s' 不是文件夹", local)
+		}
+
+		fs, err := openReadyFileSystem()
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		s := &syncer{
+			fs:     fs,
+			filter: &fileFilter{includes: syncIncludes, excludes: syncExcludes},
+			report: &syncReport{
+				Source:  local,
+				Dest:    remote,
+				DryRun:  syncDryRun,
+				StartAt: time.Now(),
+				Actions: []*syncAction{},
+			},
+		}
+
+		err = s.syncDir(ctx, local, remote, "")
+		if err != nil {
+			return err
+		}
+
+		return s.report.write(syncReportFile)
+	},
+}
+
+type syncer struct {
+	fs     *adrive.FileSystem
+	filter *fileFilter
+	report *syncReport
+}
+
+// syncDir 同步文件夹, rel 为相对同步根目录的路径
+func (s *syncer) syncDir(ctx context.Context, local, remote, rel string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	remoteFiles := map[string]os.FileInfo{}
+	fi, err := s.fs.Stat(ctx, remote)
+	if err == nil {
+		if !fi.IsDir() {
+			return fmt.Errorf("'%s' 已存在且不是文件夹", remote)
+		}
+
+		fis, err := readDir(ctx, s.fs, remote)
+		if err != nil {
+			return fmt.Errorf("列举 '%s' 失败: %v", remote, err)
+		}
+		for _, fi := range fis {
+			remoteFiles[fi.Name()] = fi
+		}
+	} else if os.IsNotExist(err) {
+		action := &syncAction{Path: remote, Action: SYNC_ACTION_MKDIR}
+		if !s.report.DryRun {
+			err = mkdirAll(ctx, s.fs, remote)
+		} else {
+			err = nil
+		}
+		s.report.add(action, err)
+		if err != nil {
+			return nil
+		}
+	} else {
+		return err
+	}
+
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		name := entry.Name()
+		childRel := path.Join(rel, name)
+		seen[name] = true
+
+		if s.filter.skip(childRel, entry.IsDir()) {
+			continue
+		}
+
+		childLocal := filepath.Join(local, name)
+		childRemote := path.Join(remote, name)
+		remoteFile := remoteFiles[name]
+
+		if entry.IsDir() {
+			if remoteFile != nil && !remoteFile.IsDir() {
+				s.report.add(&syncAction{Path: childRemote, Action: SYNC_ACTION_MKDIR}, fmt.Errorf("网盘中已存在同名文件"))
+				continue
+			}
+			if err := s.syncDir(ctx, childLocal, childRemote, childRel); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		if err := s.syncFile(ctx, childLocal, childRemote, remoteFile); err != nil {
+			return err
+		}
+	}
+
+	if !syncDelete {
+		return nil
+	}
+
+	for name, remoteFile := range remoteFiles {
+		childRel := path.Join(rel, name)
+		if seen[name] || s.filter.skip(childRel, remoteFile.IsDir()) {
+			continue
+		}
+
+		childRemote := path.Join(remote, name)
+		action := &syncAction{Path: childRemote, Action: SYNC_ACTION_DELETE, Size: remoteFile.Size()}
+		var err error
+		if !s.report.DryRun {
+			err = s.fs.RemoveAll(ctx, childRemote)
+		}
+		s.report.add(action, err)
+	}
+
+	return nil
+}
+
+// syncFile 同步单个文件, 仅在 ctx 取消时返回错误, 其他错误记录到报告中
+func (s *syncer) syncFile(ctx context.Context, local, remote string, remoteFile os.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	st, err := os.Stat(local)
+	if err != nil {
+		s.report.add(&syncAction{Path: remote, Action: SYNC_ACTION_UPLOAD}, err)
+		return nil
+	}
+
+	if remoteFile != nil && remoteFile.IsDir() {
+		s.report.add(&syncAction{Path: remote, Action: SYNC_ACTION_UPLOAD, Size: st.Size()}, fmt.Errorf("网盘中已存在同名文件夹"))
+		return nil
+	}
+
+	contentHash := ""
+	if remoteFile != nil && remoteFile.Size() == st.Size() {
+		// 大小一致且本地修改时间不晚于网盘时认为未变化, 否则比较 SHA1
+		if !syncChecksum && !st.ModTime().After(remoteFile.ModTime()) {
+			s.report.Skipped++
+			return nil
+		}
+
+		contentHash, err = fileSha1(local)
+		if err != nil {
+			s.report.add(&syncAction{Path: remote, Action: SYNC_ACTION_UPLOAD, Size: st.Size()}, err)
+			return nil
+		}
+		if file, ok := remoteFile.(*adrive.FileInfo); ok && strings.EqualFold(file.ContentHash, contentHash) {
+			s.report.Skipped++
+			return nil
+		}
+	}
+
+	action := &syncAction{Path: remote, Action: SYNC_ACTION_UPLOAD, Size: st.Size()}
+	if s.report.DryRun {
+		s.report.add(action, nil)
+		return nil
+	}
+
+	err = s.upload(ctx, local, remote, st.Size(), contentHash, remoteFile != nil, action)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	s.report.add(action, err)
+	return nil
+}
+
+// 替换已有文件时先上传到该后缀的临时文件, 上传成功后再替换旧文件
+const syncTempSuffix = ".syncing"
+
+// upload 上传文件, 优先尝试秒传. 先上传到临时文件, 成功后再重命名(replace 为 true 时先删除旧文件),
+// 上传中断或失败时删除临时文件, 不会留下不完整的文件, 网盘中的旧文件也不受影响.
+func (s *syncer) upload(ctx context.Context, local, remote string, size int64, contentHash string, replace bool, action *syncAction) error {
+	var err error
+	if contentHash == "" {
+		contentHash, err = fileSha1(local)
+		if err != nil {
+			return err
+		}
+	}
+
+	return uploadViaTemp(ctx, s.fs, remote, syncTempSuffix, replace, func(target string) error {
+		return s.uploadFile(ctx, local, target, size, contentHash, action)
+	})
+}
+
+func (s *syncer) uploadFile(ctx context.Context, local, remote string, size int64, contentHash string, action *syncAction) error {
+	in, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	rapid, err := s.fs.RapidUpload(ctx, remote, in, size, contentHash)
+	if err != nil {
+		logger.Warnf("秒传 '%s' 失败, 改为正常上传: %v", remote, err)
+	}
+	if rapid {
+		action.Action = SYNC_ACTION_RAPID_UPLOAD
+		return nil
+	}
+
+	p := newProgress("上传", local, size)
+	err = writeRemoteFile(ctx, s.fs, remote, p.reader(contextReader(ctx, in)))
+	p.done(err)
+
+	return err
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/isayme/aliyundrive-webdav/adrive"
+	"github.com/isayme/aliyundrive-webdav/util"
+	"github.com/isayme/go-logger"
+	"github.com/spf13/cobra"
+)
+
+var exportTokenJson bool
+
+func init() {
+	tokenExportCmd.Flags().BoolVar(&exportTokenJson, "json", false, "export full token as json")
+
+	tokenCmd.AddCommand(tokenExportCmd)
+	tokenCmd.AddCommand(tokenImportCmd)
+	rootCmd.AddCommand(tokenCmd)
+}
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "export or import token",
+}
+
+var tokenExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "print saved refresh token",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		db, tokenStore, _, err := openStores(adrive.Get())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		token, err := tokenStore.Load(context.Background())
+		if err != nil {
+			return err
+		}
+		if token == nil || token.RefreshToken == "" {
+			return fmt.Errorf("未登录, 没有可导出的 token")
+		}
+
+		if exportTokenJson {
+			fmt.Println(util.Stringify(token))
+		} else {
+			fmt.Println(token.RefreshToken)
+		}
+
+		logger.Warnf("refreshToken 只能使用一次, 导入到其他地方后本实例的 token 将在下次刷新时失效")
+		return nil
+	},
+}
+
+var tokenImportCmd = &cobra.Command{
+	Use:   "import <refreshToken|->",
+	Short: "import refresh token, read from stdin if '-'",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		refreshToken := args[0]
+		if refreshToken == "-" {
+			bs, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("读取标准输入失败: %v", err)
+			}
+			refreshToken = string(bs)
+		}
+		refreshToken = strings.TrimSpace(refreshToken)
+
+		fs, _, err := openFileSystem(adrive.Get())
+		if err != nil {
+			return err
+		}
+		defer fs.Close()
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		err = fs.ImportRefreshToken(ctx, refreshToken)
+		if err != nil {
+			return err
+		}
+
+		return printAccountInfo(fs)
+	},
+}